@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"fortihugorunner/dockerinternal"
 	"github.com/spf13/cobra"
@@ -17,30 +20,73 @@ var buildImageCmd = &cobra.Command{
 Example:
   fortihugorunner build-image --env author-dev
   fortihugorunner build-image --env admin-dev --hugo-version 0.146.0
+  fortihugorunner build-image --target prod --tag fortinet-hugo:test --build-arg THEME_BRANCH=main --label team=cse
+  fortihugorunner build-image --env admin-dev --list-stages --show-sources
 `,
 	//Args: cobra.ExactArgs(1), // Require exactly one argument
 	Run: func(cmd *cobra.Command, args []string) {
 		//envArg := args[0]
 		envArg, _ := cmd.Flags().GetString("env")
+		// Without --hugo-version the Dockerfile's own ARG HUGO_VERSION
+		// default applies.
 		hugoVersion, _ := cmd.Flags().GetString("hugo-version")
 
-		// Map provided argument to actual Docker build target
-		envMap := map[string]string{
-			"author-dev": "prod",
-			"admin-dev":  "dev",
+		env, err := dockerinternal.LookupEnvironment(envArg)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
-		env, exists := envMap[envArg]
-		if !exists {
-			fmt.Println("Error: env must be one of either author-dev or admin-dev.")
+
+		buildArgs, err := dockerinternal.ParseKeyValues("build-arg", getFlagStringArray(cmd, "build-arg"))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		labelValues, err := dockerinternal.ParseKeyValues("label", getFlagStringArray(cmd, "label"))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
+		labels := map[string]string{}
+		for key, value := range labelValues {
+			labels[key] = ""
+			if value != nil {
+				labels[key] = *value
+			}
+		}
+
+		// --tag replaces the environment's image name, as docker build -t does.
+		tags := getFlagStringArray(cmd, "tag")
+		if len(tags) == 0 {
+			tags = []string{env.Image}
+		}
+		target := env.Target
+		if cmd.Flags().Changed("target") {
+			target = getFlagString(cmd, "target")
+		}
+
+		cfg := dockerinternal.BuildConfig{
+			ImageName:   tags[0],
+			Tags:        tags[1:],
+			Target:      target,
+			HugoVersion: hugoVersion,
+			Dockerfile:  getFlagString(cmd, "file"),
+			BuildArgs:   buildArgs,
+			Labels:      labels,
+			PruneCache:  getFlagBool(cmd, "prune-cache"),
+			NoCache:     getFlagBool(cmd, "no-cache"),
+			CacheFrom:   getFlagStringArray(cmd, "cache-from"),
+			CacheTo:     getFlagStringArray(cmd, "cache-to"),
+		}
 
-		// Determine the corresponding container name
-		containerMap := map[string]string{
-			"prod": "fortinet-hugo",
-			"dev":  "hugotester",
+		listStages, showSources := getFlagBool(cmd, "list-stages"), getFlagBool(cmd, "show-sources")
+		if listStages || showSources {
+			if err := printDockerfile(cfg, listStages, showSources); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
 		}
-		containerName := containerMap[env]
 
 		// Initialize Docker client
 		cli, err := dockerinternal.NewDockerClient()
@@ -50,18 +96,91 @@ Example:
 		}
 
 		// Build the Docker image
-		err = dockerinternal.BuildDockerImage(cli, containerName, env, envArg, hugoVersion)
+		err = dockerinternal.BuildDockerImage(cli, cfg)
 		if err != nil {
 			fmt.Printf("Error building Docker image: %v\n", err)
+			var buildErr *dockerinternal.BuildError
+			if errors.As(err, &buildErr) && len(buildErr.Log) > 0 {
+				fmt.Println("Last build output:")
+				for _, line := range buildErr.Log {
+					fmt.Printf("  %s\n", line)
+				}
+			}
 			os.Exit(1)
 		}
 
-		fmt.Printf("**** Built a %s container named: %s ****\n", envArg, containerName)
+		fmt.Printf("**** Built a %s container named: %s ****\n", envArg, cfg.ImageName)
 	},
 }
 
+// printDockerfile prints the stages of the Dockerfile and the git sources
+// of the target stage without building.
+func printDockerfile(cfg dockerinternal.BuildConfig, listStages, showSources bool) error {
+	df, err := dockerinternal.LoadDockerfile(cfg.Dockerfile)
+	if err != nil {
+		return err
+	}
+	buildArgs := cfg.BuildArgValues()
+
+	if listStages {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STAGE\tFROM\tLINE")
+		for _, stage := range df.Stages {
+			target := ""
+			if stage.Name != "" && strings.EqualFold(stage.Name, cfg.Target) {
+				target = "  (target)"
+			}
+			fmt.Fprintf(w, "%s%s\t%s\t%d\n", stage.ID(), target, df.ExpandGlobal(stage.Base, buildArgs), stage.Line)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if showSources {
+		sources, err := df.Sources(cfg.Target, buildArgs)
+		if err != nil {
+			return err
+		}
+		if listStages {
+			fmt.Println()
+		}
+		if len(sources) == 0 {
+			fmt.Printf("Stage %s fetches no git repositories.\n", cfg.Target)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STAGE\tLINE\tINSTRUCTION\tREPOSITORY\tBRANCH")
+		for _, src := range sources {
+			branch := src.Branch
+			if branch == "" {
+				branch = "(default)"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", src.Stage, src.Line, src.Instruction, src.URL, branch)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if branch := dockerinternal.CentralRepoBranch(sources); branch != "" {
+			fmt.Printf("CentralRepo branch: %s\n", branch)
+		}
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(buildImageCmd)
-	buildImageCmd.Flags().String("env", "author-dev", "Environment. author-dev (prod) creates a fortinet-hugo image. admin-dev (dev) creates a hugotester image.")
-	buildImageCmd.Flags().String("hugo-version", "std", "Hugo base image version Go will pull before proceeding to build the <env> image. This must match the hugomods/hugo tag referenced in your Dockerfile.")
+	buildImageCmd.Flags().String("env", dockerinternal.DefaultEnvironment, dockerinternal.EnvironmentUsage())
+	buildImageCmd.Flags().String("target", "", "Dockerfile stage to build instead of the --env stage.")
+	buildImageCmd.Flags().StringArray("tag", nil, "Name and optionally tag for the image, instead of the --env image name. Repeatable.")
+	buildImageCmd.Flags().String("file", "Dockerfile", "Path of the Dockerfile inside the current directory.")
+	buildImageCmd.Flags().StringArray("build-arg", nil, "Build-time variable as KEY=VALUE, or KEY to take the value from the environment. Repeatable.")
+	buildImageCmd.Flags().StringArray("label", nil, "Image label as KEY=VALUE. Repeatable.")
+	buildImageCmd.Flags().String("hugo-version", "", "Hugo version, passed to the Dockerfile as the HUGO_VERSION build arg. Use it in the hugomods/hugo tag: FROM hugomods/hugo:${HUGO_VERSION}. Defaults to the Dockerfile's ARG HUGO_VERSION default.")
+	buildImageCmd.Flags().Bool("list-stages", false, "Print the stages of the Dockerfile and exit without building.")
+	buildImageCmd.Flags().Bool("show-sources", false, "Print the git repositories and branches the target stage fetches and exit without building.")
+	buildImageCmd.Flags().Bool("prune-cache", false, "Before building, remove the build cache left by earlier build-image runs. Cache created by other builds running at the same time may be removed too.")
+	buildImageCmd.Flags().Bool("no-cache", false, "Do not use cache when building the image.")
+	buildImageCmd.Flags().StringArray("cache-from", nil, "Image to use as a cache source, or a buildx cache spec such as 'type=local,src=.buildcache'. Repeatable.")
+	buildImageCmd.Flags().StringArray("cache-to", nil, "Buildx cache export spec such as 'type=local,dest=.buildcache'. Repeatable. Builds with this flag run through 'docker buildx build'; exporting anything but inline cache needs a docker-container builder ('docker buildx create --use').")
 }
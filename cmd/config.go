@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"fortihugorunner/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// configurableCmds are the commands whose flags can be set from config files
+// and environment variables, in the order `config show` prints them.
+var configurableCmds = []*cobra.Command{
+	launchServerCmd,
+	pullImageCmd,
+	buildImageCmd,
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect fortihugorunner configuration.",
+	Long: `Inspect fortihugorunner configuration.
+
+Flag defaults can be set in a per-workshop ` + config.ProjectFileName + ` (found by walking
+up from the current directory), in a user-level config file, or with
+` + config.EnvPrefix + `<FLAG> environment variables. Precedence, highest first:
+command-line flag, environment, project file, user file, built-in default.
+
+Example ` + config.ProjectFileName + `:
+  docker-image: fortinet-hugo:latest
+  launch-server:
+    host-port: 1313
+    watch-dir: .
+    mount-toml: true
+`,
+	// Reading configuration does not require a running Docker daemon.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show [command]",
+	Short: "Print the effective merged configuration and where each value came from.",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmds := configurableCmds
+		if len(args) == 1 {
+			cmds = nil
+			for _, c := range configurableCmds {
+				if c.Name() == args[0] {
+					cmds = append(cmds, c)
+				}
+			}
+			if len(cmds) == 0 {
+				return fmt.Errorf("unknown command %q", args[0])
+			}
+		}
+
+		cfg, err := config.Load(".")
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("User config:    %s\n", describeFile(cfg.User, config.UserFilePath()))
+		fmt.Printf("Project config: %s\n", describeFile(cfg.Project, config.ProjectFileName))
+		for _, unknown := range cfg.UnknownKeys(configFlags()) {
+			fmt.Printf("Warning: %s\n", unknown)
+		}
+
+		for _, c := range cmds {
+			fmt.Printf("\n%s:\n", c.Name())
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, rf := range cfg.ResolveFlags(c.Name(), c.NonInheritedFlags()) {
+				fmt.Fprintf(w, "  %s\t%s\t%s\n", rf.Name, strings.Join(rf.Values, ","), rf.Source)
+			}
+			w.Flush()
+		}
+		return nil
+	},
+}
+
+func describeFile(f *config.File, fallback string) string {
+	if f == nil {
+		return fallback + " (not found)"
+	}
+	return f.Path
+}
+
+// configFlags maps each configurable command to the flags config files may
+// set for it.
+func configFlags() map[string]*pflag.FlagSet {
+	flags := map[string]*pflag.FlagSet{}
+	for _, c := range configurableCmds {
+		flags[c.Name()] = c.NonInheritedFlags()
+	}
+	return flags
+}
+
+// applyConfig fills every flag not given on the command line from the
+// environment and config files, warning about keys no command knows.
+func applyConfig(cmd *cobra.Command) error {
+	cfg, err := config.Load(".")
+	if err != nil {
+		return err
+	}
+	for _, unknown := range cfg.UnknownKeys(configFlags()) {
+		fmt.Printf("Warning: %s\n", unknown)
+	}
+	return cfg.Apply(cmd.Name(), cmd.NonInheritedFlags())
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
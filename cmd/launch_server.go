@@ -2,23 +2,35 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strings"
 	"syscall"
+	"time"
 
 	"fortihugorunner/dockerinternal"
 	"github.com/spf13/cobra"
 )
 
+// exitContainerExited is the launch-server exit code when the container stops
+// before the Hugo server becomes ready, e.g. on a front matter error.
+const exitContainerExited = 3
+
+// exitInterrupted is the exit code after a forced shutdown (second Ctrl-C).
+const exitInterrupted = 130
+
 func getFlagString(cmd *cobra.Command, flagName string) string {
 	value, _ := cmd.Flags().GetString(flagName)
 	return value
 }
 
+func getFlagStringArray(cmd *cobra.Command, flagName string) []string {
+	value, _ := cmd.Flags().GetStringArray(flagName)
+	return value
+}
+
 func getFlagBool(cmd *cobra.Command, flagName string) bool {
 	value, _ := cmd.Flags().GetBool(flagName)
 	return value
@@ -45,7 +57,32 @@ Example:
 			WatchDir:      getFlagString(cmd, "watch-dir"),
 			MountToml:     getFlagBool(cmd, "mount-toml"),
 			PullLatest:    getFlagBool(cmd, "pull-latest"),
+			WatchIgnore:   getFlagStringArray(cmd, "watch-ignore"),
+		}
+
+		bindAddress, err := dockerinternal.ParseBindAddress(getFlagString(cmd, "bind-address"))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.BindAddress = bindAddress
+
+		watchMode, err := dockerinternal.ParseWatchMode(getFlagString(cmd, "watch-mode"))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
 		}
+		cfg.WatchMode = watchMode
+
+		watchBackend, err := dockerinternal.ParseWatchBackend(getFlagString(cmd, "watch-backend"))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.WatchBackend = watchBackend
+		cfg.PollInterval, _ = cmd.Flags().GetDuration("poll-interval")
+		cfg.ReadyTimeout, _ = cmd.Flags().GetDuration("ready-timeout")
+		cfg.LogLines, _ = cmd.Flags().GetInt("log-lines")
 
 		// Ensure the watch directory is absolute.
 		abs, err := filepath.Abs(cfg.WatchDir)
@@ -53,66 +90,175 @@ Example:
 			cfg.WatchDir = abs
 		}
 
-		ctx := context.Background()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 		cli, err := dockerinternal.NewDockerClient()
 		if err != nil {
 			fmt.Printf("Error creating Docker client: %v\n", err)
 			os.Exit(1)
 		}
 
-		// Check local Docker image up to date
-		fmt.Printf("PullLatest flag set to: %t", cfg.PullLatest)
-		if cfg.PullLatest == true {
-			cseImages := []string{"fortinet-hugo", "hugotester"}
-			imageName := strings.Split(cfg.DockerImage, ":")[0]
-			ecrReg := "public.ecr.aws/k4n6m5h8/"
-
-			for _, s := range cseImages {
-				if imageName == s {
-					image := ecrReg + s
-					tag := "latest"
-					err = dockerinternal.LocalImageCheck(image, tag, cli, s)
+		// Reuse a running session for this workshop instead of starting a
+		// duplicate; a stopped one would block the container name.
+		var containerID string
+		session, err := dockerinternal.FindWorkshopSession(ctx, cli, cfg.WatchDir)
+		if err != nil {
+			fmt.Printf("Error looking up existing session: %v\n", err)
+			os.Exit(1)
+		}
+		if session != nil && session.Orphaned() {
+			fmt.Printf("Found container %s for this workshop, left running by a fortihugorunner that is no longer running.\n", session.Name)
+		}
+		var url string
+		if session != nil {
+			url = dockerinternal.ServerURL(session.ServerConfig(cfg))
+		}
+		switch dockerinternal.ExistingSessionAction(session, url, confirm) {
+		case dockerinternal.SessionReattach:
+			cfg = session.ServerConfig(cfg)
+			containerID = session.ContainerID
+			fmt.Printf("Reattaching to container %s.\n", session.Name)
+		case dockerinternal.SessionReplace:
+			fmt.Printf("Replacing existing container %s for this workshop.\n", session.Name)
+			dockerinternal.StopAndRemoveContainer(cli, session.ContainerID)
+		case dockerinternal.SessionAbort:
+			fmt.Printf("Leaving container %s running. Stop it with 'fortihugorunner stop %s' to start a new session.\n", session.Name, session.Name)
+			os.Exit(1)
+		}
+		reattached := containerID != ""
+
+		if !reattached {
+			// Check local Docker image up to date
+			fmt.Printf("PullLatest flag set to: %t\n", cfg.PullLatest)
+			if getFlagBool(cmd, "offline") {
+				fmt.Println("Offline mode: skipping the registry check.")
+				if err := dockerinternal.RequireLocalImage(cli, cfg.DockerImage); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+			} else if cfg.PullLatest == true {
+				if remote, ok := dockerinternal.RunnerImageRef(cfg.DockerImage, getFlagString(cmd, "registry")); ok {
+					registry := dockerinternal.NewRegistry(getFlagStringArray(cmd, "insecure-registry"))
+					ttl, _ := cmd.Flags().GetDuration("digest-cache-ttl")
+					if registry.Cache = dockerinternal.NewDigestCache(ttl); registry.Cache != nil {
+						registry.Cache.Refresh = getFlagBool(cmd, "refresh")
+					}
+					err = dockerinternal.LocalImageCheck(cli, registry, remote, cfg.DockerImage)
 					if err != nil {
-						fmt.Printf("Error in LocalImageCheck: %v", err)
-						log.Fatal(err)
+						fmt.Printf("Error in LocalImageCheck: %v\n", err)
+						os.Exit(1)
 					}
-					break
 				}
 			}
+
+			// Containers orphaned by a killed runner keep holding their port.
+			stale, err := dockerinternal.FindStaleSessions(ctx, cli, cfg)
+			if err != nil {
+				fmt.Printf("Error looking up stale containers: %v\n", err)
+				os.Exit(1)
+			}
+			for _, s := range stale {
+				if confirm(fmt.Sprintf("Container %s (workshop %s) was left running by a fortihugorunner that is no longer running and holds port %s. Remove it?", s.Name, s.Workshop, s.HostPort), true) {
+					dockerinternal.StopAndRemoveContainer(cli, s.ContainerID)
+				}
+			}
+
+			if err := dockerinternal.ResolveHostPort(ctx, cli, &cfg, getFlagBool(cmd, "port-fallback")); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("\n**** Serving workshop on %s ****\n", dockerinternal.ServerURL(cfg))
+			if dockerinternal.SharesOnLAN(cfg) {
+				fmt.Println("Warning: the workshop is reachable by other machines on your network at:")
+				for _, url := range dockerinternal.LANURLs(cfg) {
+					fmt.Printf("  %s\n", url)
+				}
+			}
+			fmt.Println()
 		}
 
-		containerID, err := dockerinternal.StartContainer(ctx, cli, cfg)
+		// The first signal shuts down gracefully; a second one force-removes
+		// the container and exits without waiting.
+		server := dockerinternal.NewServerSession(cli, cfg)
+		sigChan := make(chan os.Signal, 2)
+		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigChan)
+		go func() {
+			<-sigChan
+			fmt.Println("\nReceived shutdown signal. Stopping container (press Ctrl-C again to force).")
+			cancel()
+			<-sigChan
+			fmt.Println("\nForcing shutdown.")
+			killCtx, killCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer killCancel()
+			if err := server.Kill(killCtx); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+			os.Exit(exitInterrupted)
+		}()
+
+		if reattached {
+			err = server.Adopt(ctx, containerID)
+		} else {
+			err = server.Start(ctx)
+		}
 		if err != nil {
 			fmt.Printf("Error starting container: %v\n", err)
+			server.Shutdown()
 			os.Exit(1)
 		}
 
-		if err := dockerinternal.AttachContainer(ctx, cli, containerID); err != nil {
-			fmt.Printf("Error attaching container: %v\n", err)
-			os.Exit(1)
-		}
-
-		// Setup signal handling.
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+		// Watch for file changes until a shutdown signal cancels ctx. The
+		// watcher starts before the readiness wait so edits saved during
+		// Hugo's initial build are not lost.
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		defer stopWatching()
+		watchDone := make(chan struct{})
 		go func() {
-			<-sigChan
-			fmt.Println("\nReceived shutdown signal. Stopping container.")
-			dockerinternal.StopAndRemoveContainer(cli, containerID)
-			os.Exit(0)
+			defer close(watchDone)
+			dockerinternal.WatchAndRestart(watchCtx, server)
 		}()
 
-		// Start file watcher.
-		dockerinternal.WatchAndRestart(ctx, cli, cfg, &containerID)
+		// Wait for Hugo to finish the initial build before reporting success.
+		if cfg.ReadyTimeout > 0 && !reattached {
+			err := server.WaitForReady(ctx)
+			if ctx.Err() == nil {
+				dockerinternal.ReportReadiness(err, dockerinternal.ServerURL(cfg))
+			}
+			var exited *dockerinternal.ContainerExitedError
+			if errors.As(err, &exited) {
+				stopWatching()
+				<-watchDone
+				server.Shutdown()
+				os.Exit(exitContainerExited)
+			}
+		}
+
+		// Remove the container once any restart in progress has finished.
+		<-watchDone
+		server.Shutdown()
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(launchServerCmd)
-	launchServerCmd.Flags().String("docker-image", "fortinet-hugo:latest", "Docker image to use")
-	launchServerCmd.Flags().String("host-port", "1313", "Host port to expose")
+	launchServerCmd.Flags().String("docker-image", dockerinternal.DefaultImage, "Docker image to use")
+	launchServerCmd.Flags().String("host-port", "1313", "Host port to expose, or 'auto' to use the first free port starting at --container-port")
+	launchServerCmd.Flags().Bool("port-fallback", false, "If --host-port is already in use, use the next free port instead of failing.")
+	launchServerCmd.Flags().String("bind-address", dockerinternal.DefaultBindAddress, "Host address to publish the port on. Defaults to loopback so only this machine can reach the server. Use '::1' for IPv6 loopback, a LAN interface address, or '0.0.0.0'/'::' to share on all interfaces.")
 	launchServerCmd.Flags().String("container-port", "1313", "Container port to expose")
 	launchServerCmd.Flags().String("watch-dir", ".", "Directory to watch for file changes")
 	launchServerCmd.Flags().Bool("mount-toml", false, "Use '--mount-toml=true' to mount the hugo.toml in your workshop directory and watch for updates.")
+	launchServerCmd.Flags().String("watch-mode", "restart", "How to react to file changes: 'hugo' relies on Hugo's livereload and restarts only for config/theme changes, 'restart' recreates the container, 'rebuild' runs a Hugo build inside the running container.")
+	launchServerCmd.Flags().StringArray("watch-ignore", nil, "Gitignore-style pattern of paths under --watch-dir to ignore. Repeatable. Added to .gitignore, .hugoignore and the built-in ignores (.git/, public/, resources/, node_modules/).")
+	launchServerCmd.Flags().String("watch-backend", "auto", "How file changes are detected: 'notify' uses OS file events, 'poll' rescans --watch-dir periodically (for WSL2 /mnt/ drives, network shares and some VM filesystems), 'auto' picks poll on WSL2 /mnt/ paths and notify elsewhere.")
+	launchServerCmd.Flags().Duration("poll-interval", dockerinternal.DefaultPollInterval, "Rescan interval for '--watch-backend=poll'.")
+	launchServerCmd.Flags().Duration("ready-timeout", 60*time.Second, "How long to wait for the Hugo server to answer before warning that it is not ready. Use '--ready-timeout=0' to skip the check.")
+	launchServerCmd.Flags().Int("log-lines", 20, "Number of container log lines to print if the container exits during startup.")
+	launchServerCmd.Flags().String("registry", dockerinternal.DefaultRegistry, "Registry prefix the fortinet-hugo and hugotester images are checked against for --pull-latest, e.g. 'ghcr.io/my-team/' for a mirror.")
+	launchServerCmd.Flags().StringArray("insecure-registry", nil, "Registry (host or host:port) that may be reached over plain HTTP or HTTPS with an untrusted certificate. Repeatable.")
 	launchServerCmd.Flags().Bool("pull-latest", true, "Check local Docker image is up-to-date. If not, download latest. Use '--pull-latest=false' to disable.")
+	launchServerCmd.Flags().Duration("digest-cache-ttl", dockerinternal.DefaultDigestCacheTTL, "How long a remote image digest is reused before --pull-latest asks the registry again. Use '--digest-cache-ttl=0' to always ask.")
+	launchServerCmd.Flags().Bool("refresh", false, "Ignore cached remote digests and ask the registry.")
+	launchServerCmd.Flags().Bool("offline", false, "Skip the registry check and use the local image. Without this flag, an unreachable registry also falls back to the local image with a warning.")
 }
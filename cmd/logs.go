@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"fortihugorunner/dockerinternal"
+	"github.com/moby/moby/client"
+	"github.com/spf13/cobra"
+)
+
+// logLine is the `logs --json` representation of one line of output.
+type logLine struct {
+	Container string `json:"container"`
+	Timestamp string `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [workshop-dir|container-name]",
+	Short: "Show the Hugo server output of a workshop container.",
+	Long: `Show the output of the container serving a workshop. The workshop defaults to
+the current directory; a container name from 'fortihugorunner ps' or a container
+ID prefix of at least 4 characters also works.
+
+Example:
+  fortihugorunner logs
+  fortihugorunner logs -f --since 10m
+  fortihugorunner logs --tail 50 --json
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		follow, _ := cmd.Flags().GetBool("follow")
+		since, _ := cmd.Flags().GetString("since")
+		tail, _ := cmd.Flags().GetString("tail")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		ctx := context.Background()
+		cli, err := dockerinternal.NewDockerClient()
+		if err != nil {
+			return fmt.Errorf("could not create Docker client: %w", err)
+		}
+		target := "."
+		if len(args) == 1 {
+			target = args[0]
+		}
+		session, err := dockerinternal.ResolveSession(ctx, cli, target)
+		if err != nil {
+			return err
+		}
+
+		out, err := cli.ContainerLogs(ctx, session.ContainerID, client.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Follow:     follow,
+			Since:      since,
+			Tail:       tail,
+			Timestamps: asJSON,
+		})
+		if err != nil {
+			return fmt.Errorf("could not read logs: %w", err)
+		}
+		defer out.Close()
+
+		// Server containers run with a TTY, so the stream is plain text.
+		if !asJSON {
+			_, err = io.Copy(os.Stdout, out)
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			timestamp, message, _ := strings.Cut(strings.TrimRight(scanner.Text(), "\r"), " ")
+			if err := enc.Encode(logLine{Container: session.Name, Timestamp: timestamp, Message: message}); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.Flags().BoolP("follow", "f", false, "Keep streaming new output.")
+	logsCmd.Flags().String("since", "", "Only show output since a timestamp (e.g. 2026-01-02T13:23:37Z) or relative duration (e.g. 10m).")
+	logsCmd.Flags().String("tail", "all", "Number of lines to show from the end of the logs.")
+	logsCmd.Flags().Bool("json", false, "Print one JSON object per line with its timestamp.")
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"fortihugorunner/dockerinternal"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove stopped runner containers, dangling runner images and build cache.",
+	Long: `Remove what fortihugorunner leaves behind: stopped workshop containers,
+dangling fortinet-hugo/hugotester images and the build cache created by
+build-image. Build cache is attributed on a best-effort basis: every record
+that appeared while build-image was running counts as the runner's, including
+records from other builds running on the same daemon at the time.
+
+Example:
+  fortihugorunner prune --dry-run
+  fortihugorunner prune --force
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		force, _ := cmd.Flags().GetBool("force")
+
+		ctx := context.Background()
+		cli, err := dockerinternal.NewDockerClient()
+		if err != nil {
+			return fmt.Errorf("could not create Docker client: %w", err)
+		}
+		plan, err := dockerinternal.PlanPrune(ctx, cli)
+		if err != nil {
+			return err
+		}
+		if plan.Empty() {
+			fmt.Println("Nothing to prune.")
+			return nil
+		}
+
+		printPrunePlan(plan)
+		if dryRun {
+			return nil
+		}
+		if !force && !confirm("Remove these?", false) {
+			fmt.Println("Nothing removed.")
+			return nil
+		}
+
+		report := dockerinternal.Prune(ctx, cli, plan)
+		fmt.Printf("Removed %d container(s), %d image(s) and %d build cache record(s); reclaimed %s.\n",
+			report.ContainersRemoved, report.ImagesRemoved, report.CacheRemoved, dockerinternal.FormatBytes(int64(report.SpaceReclaimed)))
+		for _, err := range report.Errors {
+			fmt.Printf("Error: %v\n", err)
+		}
+		if len(report.Errors) > 0 {
+			return fmt.Errorf("prune finished with %d error(s)", len(report.Errors))
+		}
+		return nil
+	},
+}
+
+func printPrunePlan(plan *dockerinternal.PrunePlan) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME\tSIZE\tDETAIL")
+	for _, c := range plan.Containers {
+		fmt.Fprintf(w, "container\t%s\t%s\t%s\n", c.Name, dockerinternal.FormatBytes(c.Size), c.Workshop)
+	}
+	for _, img := range plan.Images {
+		name := dockerinternal.ShortID(img.ID)
+		if len(img.RepoDigests) > 0 {
+			name, _, _ = strings.Cut(img.RepoDigests[0], "@")
+		}
+		fmt.Fprintf(w, "image\t%s\t%s\t%s\n", name, dockerinternal.FormatBytes(img.Size), dockerinternal.ShortID(img.ID))
+	}
+	for _, rec := range plan.BuildCache {
+		fmt.Fprintf(w, "build cache\t%s\t%s\t%s\n", dockerinternal.ShortID(rec.ID), dockerinternal.FormatBytes(rec.Size), rec.Description)
+	}
+	w.Flush()
+	fmt.Printf("Total: %s\n", dockerinternal.FormatBytes(plan.TotalSize()))
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+	pruneCmd.Flags().Bool("dry-run", false, "List what would be removed, with sizes, without removing anything.")
+	pruneCmd.Flags().BoolP("force", "f", false, "Do not prompt for confirmation.")
+}
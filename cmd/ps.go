@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"fortihugorunner/dockerinternal"
+	"github.com/spf13/cobra"
+)
+
+// sessionInfo is the `ps --json` representation of a runner container.
+type sessionInfo struct {
+	Container string `json:"container"`
+	Name      string `json:"name"`
+	Workshop  string `json:"workshop"`
+	URL       string `json:"url"`
+	Image     string `json:"image"`
+	ImageID   string `json:"image_id"`
+	Digest    string `json:"digest"`
+	Version   string `json:"version"`
+	State     string `json:"state"`
+	Status    string `json:"status"`
+	Uptime    string `json:"uptime,omitempty"`
+}
+
+var psCmd = &cobra.Command{
+	Use:   "ps",
+	Short: "List workshop containers started by fortihugorunner.",
+	Long: `List workshop containers started by fortihugorunner, with their workshop
+directory, URL, image, image repo digest, uptime and status. Images built
+locally have no repo digest.
+
+Example:
+  fortihugorunner ps
+  fortihugorunner ps --all --json
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		cli, err := dockerinternal.NewDockerClient()
+		if err != nil {
+			return fmt.Errorf("could not create Docker client: %w", err)
+		}
+		ctx := context.Background()
+		sessions, err := dockerinternal.ListSessions(ctx, cli, all)
+		if err != nil {
+			return fmt.Errorf("could not list containers: %w", err)
+		}
+
+		infos := make([]sessionInfo, 0, len(sessions))
+		for _, s := range sessions {
+			info := sessionInfo{
+				Container: s.ContainerID,
+				Name:      s.Name,
+				Workshop:  s.Workshop,
+				URL:       dockerinternal.ServerURL(s.ServerConfig(dockerinternal.ServerConfig{})),
+				Image:     s.Image,
+				ImageID:   s.ImageID,
+				Digest:    s.RepoDigest(ctx, cli),
+				Version:   s.Version,
+				State:     s.State,
+				Status:    s.Status,
+			}
+			if s.Running() {
+				info.Uptime = time.Since(s.Created).Round(time.Second).String()
+			}
+			infos = append(infos, info)
+		}
+
+		if asJSON {
+			return printJSON(infos)
+		}
+		if len(infos) == 0 {
+			fmt.Println("No fortihugorunner containers found.")
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "WORKSHOP\tURL\tIMAGE\tDIGEST\tUPTIME\tSTATUS")
+		for _, info := range infos {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", info.Workshop, info.URL, info.Image, dashIfEmpty(info.Digest), dashIfEmpty(info.Uptime), info.Status)
+		}
+		return w.Flush()
+	},
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	rootCmd.AddCommand(psCmd)
+	psCmd.Flags().BoolP("all", "a", false, "Include stopped containers.")
+	psCmd.Flags().Bool("json", false, "Print the list as JSON.")
+}
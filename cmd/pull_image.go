@@ -1,12 +1,10 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"os"
 
 	"fortihugorunner/dockerinternal"
-	"github.com/moby/moby/client"
 	"github.com/spf13/cobra"
 )
 
@@ -27,23 +25,12 @@ Example:
 		envArg, _ := cmd.Flags().GetString("env")
 		ecrReg, _ := cmd.Flags().GetString("registry")
 
-		// Map provided argument to actual Docker build target
-		envMap := map[string]string{
-			"author-dev": "prod",
-			"admin-dev":  "dev",
-		}
-		env, exists := envMap[envArg]
-		if !exists {
-			fmt.Println("Error: env must be one of either author-dev or admin-dev.")
+		env, err := dockerinternal.LookupEnvironment(envArg)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			os.Exit(1)
 		}
-
-		// Determine the corresponding container name
-		containerMap := map[string]string{
-			"prod": "fortinet-hugo",
-			"dev":  "hugotester",
-		}
-		containerName := containerMap[env]
+		containerName := env.Image
 
 		// Initialize Docker client
 		cli, err := dockerinternal.NewDockerClient()
@@ -52,27 +39,20 @@ Example:
 			os.Exit(1)
 		}
 
-		// Pull the Docker image
+		// Pull the Docker image and tag it with its short name
 		fullUri := ecrReg + containerName + ":latest"
-		err = dockerinternal.EnsureImagePulled(cli, fullUri)
+		err = dockerinternal.PullAndTag(cli, fullUri, containerName)
 		if err != nil {
 			fmt.Printf("Error pulling Docker image: %v\n", err)
 			os.Exit(1)
 		}
 
-		// Tag the image
-		_, err = cli.ImageTag(context.Background(), client.ImageTagOptions{Source: fullUri, Target: containerName})
-		if err != nil {
-			fmt.Printf("Error re-tagging image: %v\n", err)
-			os.Exit(1)
-		}
-
 		fmt.Printf("**** Image %s successfully pulled and tagged as: %s ****\n", fullUri, containerName)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(pullImageCmd)
-	pullImageCmd.Flags().String("env", "author-dev", "Environment. author-dev (prod) creates a fortinet-hugo image. admin-dev (dev) creates a hugotester image.")
-	pullImageCmd.Flags().String("registry", "public.ecr.aws/k4n6m5h8/", "ECR registry.")
+	pullImageCmd.Flags().String("env", dockerinternal.DefaultEnvironment, dockerinternal.EnvironmentUsage())
+	pullImageCmd.Flags().String("registry", dockerinternal.DefaultRegistry, "ECR registry.")
 }
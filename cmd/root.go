@@ -21,6 +21,12 @@ var rootCmd = &cobra.Command{
 		DisableDefaultCmd: true,
 	},
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyConfig(cmd); err != nil {
+			return err
+		}
+		if readsLocalFilesOnly(cmd) {
+			return nil
+		}
 		err := checkDockerRunning()
 		if err != nil {
 			cmd.SilenceErrors = true
@@ -43,6 +49,19 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// localOnlyFlags make a command only read local files, e.g.
+// build-image --list-stages, so it does not need a running Docker daemon.
+var localOnlyFlags = []string{"list-stages", "show-sources"}
+
+func readsLocalFilesOnly(cmd *cobra.Command) bool {
+	for _, name := range localOnlyFlags {
+		if cmd.Flags().Lookup(name) != nil && getFlagBool(cmd, name) {
+			return true
+		}
+	}
+	return false
+}
+
 func checkDockerRunning() error {
 	ctx := context.Background()
 	cli, err := dockerinternal.NewDockerClient()
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"fortihugorunner/dockerinternal"
+	"github.com/spf13/cobra"
+)
+
+// stopResult is the `stop --json` representation of one stopped container.
+type stopResult struct {
+	Container string `json:"container"`
+	Name      string `json:"name"`
+	Workshop  string `json:"workshop"`
+	Error     string `json:"error,omitempty"`
+}
+
+var stopCmd = &cobra.Command{
+	Use:   "stop [workshop-dir|container-name]",
+	Short: "Stop and remove workshop containers started by fortihugorunner.",
+	Long: `Stop and remove the container serving a workshop. The workshop defaults to the
+current directory; a container name from 'fortihugorunner ps' or a container ID
+prefix of at least 4 characters also works.
+
+Example:
+  fortihugorunner stop
+  fortihugorunner stop ~/workshops/fortigate-101
+  fortihugorunner stop --all
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		if all && len(args) > 0 {
+			return fmt.Errorf("--all cannot be combined with a workshop argument")
+		}
+
+		ctx := context.Background()
+		cli, err := dockerinternal.NewDockerClient()
+		if err != nil {
+			return fmt.Errorf("could not create Docker client: %w", err)
+		}
+
+		var sessions []dockerinternal.Session
+		if all {
+			sessions, err = dockerinternal.ListSessions(ctx, cli, true)
+			if err != nil {
+				return fmt.Errorf("could not list containers: %w", err)
+			}
+		} else {
+			target := "."
+			if len(args) == 1 {
+				target = args[0]
+			}
+			session, err := dockerinternal.ResolveSession(ctx, cli, target)
+			if err != nil {
+				return err
+			}
+			sessions = append(sessions, *session)
+		}
+
+		if !asJSON {
+			if len(sessions) == 0 {
+				fmt.Println("No fortihugorunner containers found.")
+			}
+			for _, s := range sessions {
+				fmt.Printf("Workshop: %s\n", s.Workshop)
+				dockerinternal.StopAndRemoveContainer(cli, s.ContainerID)
+			}
+			return nil
+		}
+
+		// JSON output must not be interleaved with progress messages.
+		results := make([]stopResult, 0, len(sessions))
+		for _, s := range sessions {
+			r := stopResult{Container: s.ContainerID, Name: s.Name, Workshop: s.Workshop}
+			if err := dockerinternal.RemoveContainer(ctx, cli, s.ContainerID); err != nil {
+				r.Error = err.Error()
+			}
+			results = append(results, r)
+		}
+		return printJSON(results)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stopCmd)
+	stopCmd.Flags().Bool("all", false, "Stop every fortihugorunner container.")
+	stopCmd.Flags().Bool("json", false, "Print the stopped containers as JSON.")
+}
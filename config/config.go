@@ -0,0 +1,197 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectFileName is the per-workshop config file, discovered by walking up
+// from the working directory.
+const ProjectFileName = ".fortihugorunner.yaml"
+
+// EnvPrefix is prepended to upper-cased flag names (dashes become underscores)
+// to form environment overrides, e.g. FORTIHUGORUNNER_HOST_PORT.
+const EnvPrefix = "FORTIHUGORUNNER_"
+
+// Source labels used when reporting where an effective value came from.
+const (
+	SourceDefault = "default"
+	SourceFlag    = "flag"
+)
+
+// pathKeys are settings whose relative values are resolved against the
+// directory of the config file that set them rather than the working directory.
+var pathKeys = map[string]bool{
+	"watch-dir": true,
+}
+
+// File is a parsed config file. Top-level scalar keys apply to every command;
+// top-level mappings named after a command override them for that command only.
+//
+//	host-port: 1313
+//	launch-server:
+//	  mount-toml: true
+//	build-image:
+//	  env: admin-dev
+type File struct {
+	Path     string
+	global   map[string][]string
+	commands map[string]map[string][]string
+}
+
+// Config is the set of config layers discovered for a working directory.
+type Config struct {
+	User    *File
+	Project *File
+}
+
+// Load discovers the user-level config under the OS config dir and the nearest
+// project config at or above dir. Missing files are not an error.
+func Load(dir string) (*Config, error) {
+	cfg := &Config{}
+
+	if userPath := UserFilePath(); userPath != "" {
+		f, err := readFile(userPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.User = f
+	}
+
+	if projectPath := FindProjectFile(dir); projectPath != "" {
+		f, err := readFile(projectPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Project = f
+	}
+	return cfg, nil
+}
+
+// UserFilePath returns the location of the user-level config file, or an empty
+// string when the OS config dir cannot be determined.
+func UserFilePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "fortihugorunner", "config.yaml")
+}
+
+// FindProjectFile walks up from dir looking for ProjectFileName and returns
+// the first match, or an empty string if none exists.
+func FindProjectFile(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		candidate := filepath.Join(abs, ProjectFileName)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return ""
+		}
+		abs = parent
+	}
+}
+
+// Lookup resolves key for command using the precedence env > project > user.
+// It returns the values, a description of their source and whether any layer
+// set the key. Command-line flags sit above all of these and are handled by
+// the caller.
+func (c *Config) Lookup(command, key string) ([]string, string, bool) {
+	envName := EnvName(key)
+	if v, ok := os.LookupEnv(envName); ok {
+		return []string{v}, "env " + envName, true
+	}
+	for _, f := range []*File{c.Project, c.User} {
+		if f == nil {
+			continue
+		}
+		if v, ok := f.lookup(command, key); ok {
+			return v, f.Path, true
+		}
+	}
+	return nil, "", false
+}
+
+// EnvName returns the environment variable that overrides key.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+func (f *File) lookup(command, key string) ([]string, bool) {
+	if section, ok := f.commands[command]; ok {
+		if v, ok := section[key]; ok {
+			return v, true
+		}
+	}
+	v, ok := f.global[key]
+	return v, ok
+}
+
+func readFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	f := &File{
+		Path:     path,
+		global:   map[string][]string{},
+		commands: map[string]map[string][]string{},
+	}
+	baseDir := filepath.Dir(path)
+	for key, value := range raw {
+		if section, ok := value.(map[string]interface{}); ok {
+			values := map[string][]string{}
+			for k, v := range section {
+				values[k] = normalize(k, v, baseDir)
+			}
+			f.commands[key] = values
+			continue
+		}
+		f.global[key] = normalize(key, value, baseDir)
+	}
+	return f, nil
+}
+
+// normalize flattens a YAML value into the string form cobra flags accept.
+func normalize(key string, value interface{}, baseDir string) []string {
+	var values []string
+	switch v := value.(type) {
+	case nil:
+		values = []string{""}
+	case []interface{}:
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+	default:
+		values = []string{fmt.Sprint(v)}
+	}
+
+	if pathKeys[key] {
+		for i, v := range values {
+			if v != "" && !filepath.IsAbs(v) {
+				values[i] = filepath.Join(baseDir, v)
+			}
+		}
+	}
+	return values
+}
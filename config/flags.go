@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/pflag"
+)
+
+// ResolvedFlag is the effective value of a single flag and where it came from.
+type ResolvedFlag struct {
+	Name   string
+	Values []string
+	Source string
+}
+
+// ResolveFlags computes the effective value of every flag in flags for
+// command. Flags set on the command line win over every config layer.
+func (c *Config) ResolveFlags(command string, flags *pflag.FlagSet) []ResolvedFlag {
+	var resolved []ResolvedFlag
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" {
+			return
+		}
+		rf := ResolvedFlag{Name: f.Name, Values: []string{f.Value.String()}, Source: SourceDefault}
+		if f.Changed {
+			rf.Source = SourceFlag
+		} else if values, source, ok := c.Lookup(command, f.Name); ok {
+			rf.Values = values
+			rf.Source = source
+		}
+		resolved = append(resolved, rf)
+	})
+	return resolved
+}
+
+// Apply fills every flag in flags not given on the command line from the
+// environment and config files.
+func (c *Config) Apply(command string, flags *pflag.FlagSet) error {
+	for _, rf := range c.ResolveFlags(command, flags) {
+		if rf.Source == SourceFlag || rf.Source == SourceDefault {
+			continue
+		}
+		f := flags.Lookup(rf.Name)
+		for _, v := range rf.Values {
+			if err := f.Value.Set(v); err != nil {
+				return fmt.Errorf("invalid value %q for %s from %s: %w", v, rf.Name, rf.Source, err)
+			}
+		}
+	}
+	return nil
+}
+
+// UnknownKeys describes the keys of the config files that set no flag of
+// the configurable commands, e.g. a misspelt host-prot. commands maps each
+// command name to its flags.
+func (c *Config) UnknownKeys(commands map[string]*pflag.FlagSet) []string {
+	known := func(command, key string) bool {
+		if command != "" {
+			return commands[command].Lookup(key) != nil
+		}
+		for _, flags := range commands {
+			if flags.Lookup(key) != nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	var unknown []string
+	for _, f := range []*File{c.Project, c.User} {
+		if f == nil {
+			continue
+		}
+		for _, key := range sortedKeys(f.global) {
+			if !known("", key) {
+				unknown = append(unknown, fmt.Sprintf("%s: unknown key %q", f.Path, key))
+			}
+		}
+		for _, command := range sortedKeys(f.commands) {
+			if _, ok := commands[command]; !ok {
+				unknown = append(unknown, fmt.Sprintf("%s: unknown command %q", f.Path, command))
+				continue
+			}
+			for _, key := range sortedKeys(f.commands[command]) {
+				if !known(command, key) {
+					unknown = append(unknown, fmt.Sprintf("%s: unknown key %q for %s", f.Path, key, command))
+				}
+			}
+		}
+	}
+	return unknown
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package dockerinternal
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// DefaultBindAddress keeps unreleased workshop content on this machine.
+const DefaultBindAddress = "127.0.0.1"
+
+// ParseBindAddress validates a --bind-address value. It accepts "localhost",
+// loopback and unspecified addresses (0.0.0.0, ::) and addresses assigned to
+// a local network interface.
+func ParseBindAddress(s string) (netip.Addr, error) {
+	if strings.EqualFold(s, "localhost") {
+		return netip.MustParseAddr(DefaultBindAddress), nil
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid bind address %q: %w", s, err)
+	}
+	if addr.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("invalid bind address %q: zoned addresses are not supported", s)
+	}
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsUnspecified() {
+		return addr, nil
+	}
+	for _, local := range interfaceAddrs() {
+		if local == addr {
+			return addr, nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("invalid bind address %q: not assigned to any local network interface", s)
+}
+
+// ServerURL returns the address the Hugo server is published on.
+func ServerURL(cfg ServerConfig) string {
+	host := "localhost"
+	addr := cfg.BindAddress
+	if addr.IsValid() && !addr.IsUnspecified() && addr != netip.MustParseAddr(DefaultBindAddress) {
+		host = addr.String()
+	}
+	return "http://" + net.JoinHostPort(host, cfg.HostPort) + "/"
+}
+
+// SharesOnLAN reports whether the server is reachable from other machines.
+func SharesOnLAN(cfg ServerConfig) bool {
+	return cfg.BindAddress.IsValid() && !cfg.BindAddress.IsLoopback()
+}
+
+// LANURLs returns the URLs other machines can use to reach the server.
+func LANURLs(cfg ServerConfig) []string {
+	if !SharesOnLAN(cfg) {
+		return nil
+	}
+	var addrs []netip.Addr
+	if cfg.BindAddress.IsUnspecified() {
+		for _, local := range interfaceAddrs() {
+			if local.IsLoopback() || local.IsLinkLocalUnicast() {
+				continue
+			}
+			// 0.0.0.0 only publishes on IPv4; :: covers both families.
+			if cfg.BindAddress.Is4() && !local.Is4() {
+				continue
+			}
+			addrs = append(addrs, local)
+		}
+	} else {
+		addrs = append(addrs, cfg.BindAddress)
+	}
+
+	urls := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		urls = append(urls, "http://"+net.JoinHostPort(addr.String(), cfg.HostPort)+"/")
+	}
+	return urls
+}
+
+func interfaceAddrs() []netip.Addr {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var addrs []netip.Addr
+	for _, a := range ifaceAddrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, prefix.Addr().Unmap())
+	}
+	return addrs
+}
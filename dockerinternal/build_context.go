@@ -0,0 +1,161 @@
+package dockerinternal
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+// DockerIgnoreFile lists paths left out of the build context, with the same
+// pattern syntax as docker build.
+const DockerIgnoreFile = ".dockerignore"
+
+// CreateTarball streams dir as a docker build context. Paths matched by
+// dir's .dockerignore are left out, except for dockerfile and the ignore
+// file itself, which the daemon always needs. Directories, symlinks and file
+// modes are recorded, with slash-separated names on every OS. The archive is
+// written while it is read; closing the reader stops the writer.
+func CreateTarball(dir string, dockerfile string) (io.ReadCloser, error) {
+	excludes, err := readDockerIgnore(dir)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := patternmatcher.New(excludes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", DockerIgnoreFile, err)
+	}
+	keep := map[string]bool{
+		filepath.ToSlash(filepath.Clean(dockerfile)): true,
+		DockerIgnoreFile: true,
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeBuildContext(pw, dir, pm, keep))
+	}()
+	return pr, nil
+}
+
+// readDockerIgnore returns the patterns in dir's .dockerignore, if any.
+func readDockerIgnore(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, DockerIgnoreFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	patterns, err := ignorefile.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", DockerIgnoreFile, err)
+	}
+	return patterns, nil
+}
+
+func writeBuildContext(w io.Writer, dir string, pm *patternmatcher.PatternMatcher, keep map[string]bool) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+
+		if !keep[name] {
+			excluded, err := pm.MatchesOrParentMatches(name)
+			if err != nil {
+				return err
+			}
+			if excluded {
+				// With "!" exceptions, or a Dockerfile below it, something
+				// in the directory is still included, so it is walked.
+				if d.IsDir() && !pm.Exclusions() && !keepsBelow(keep, name) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return addTarEntry(tw, path, name, info)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func keepsBelow(keep map[string]bool, dir string) bool {
+	for name := range keep {
+		if strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// addTarEntry writes one file, directory or symlink. Other file types, such
+// as sockets, cannot be part of a build context and are skipped.
+func addTarEntry(tw *tar.Writer, path, name string, info fs.FileInfo) error {
+	var link string
+	switch mode := info.Mode(); {
+	case mode.IsRegular(), mode.IsDir():
+	case mode&fs.ModeSymlink != 0:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		link = filepath.ToSlash(target)
+	default:
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	// The daemon does not use the host's owners.
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if runtime.GOOS == "windows" {
+		// Windows has no executable bit; mark everything executable as
+		// docker build does, so scripts keep working in the image.
+		hdr.Mode = (hdr.Mode &^ 0o7777) | ((hdr.Mode & 0o755) | 0o111)
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,312 @@
+package dockerinternal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// buildLogLines is how many lines of output a BuildError keeps.
+const buildLogLines = 10
+
+// buildkitTraceID marks stream messages whose aux field holds a BuildKit
+// StatusResponse protobuf.
+const buildkitTraceID = "moby.buildkit.trace"
+
+// BuildError is a failed image build: the step that failed, the error the
+// daemon reported and the last lines of output before it.
+type BuildError struct {
+	// Step is the failing instruction, e.g. "RUN hugo" or, for BuildKit,
+	// "[prod 2/3] RUN hugo". It is empty if the step is not known.
+	Step string
+	// Log holds the last lines of output, from the failing step if known.
+	Log []string
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	if e.Step == "" {
+		return "build failed: " + e.Err.Error()
+	}
+	return fmt.Sprintf("build step %q failed: %s", e.Step, e.Err)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// DisplayBuildOutput prints the daemon's build stream from r as plain text,
+// including BuildKit progress, and returns a *BuildError if the build fails.
+func DisplayBuildOutput(r io.Reader, out io.Writer) error {
+	b := &buildOutput{out: out, vertices: map[string]*buildVertex{}}
+	dec := json.NewDecoder(r)
+	for {
+		var msg streamMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("error reading build output: %w", err)
+		}
+		switch {
+		case msg.Error != nil:
+			return b.failure(&StreamError{Code: msg.Error.Code, Message: msg.Error.Message})
+		case msg.ErrorMessage != "":
+			return b.failure(&StreamError{Message: msg.ErrorMessage})
+		case msg.ID == buildkitTraceID && msg.Aux != nil:
+			if err := b.trace(*msg.Aux); err != nil {
+				return err
+			}
+		case msg.Stream != "":
+			b.stream(msg.Stream)
+		case msg.Status != "":
+			b.println(formatStatus(msg.Message, false))
+		}
+	}
+	// BuildKit may report a failed vertex without a final error message.
+	if b.failed != nil {
+		return b.failure(errors.New(b.failed.err))
+	}
+	return nil
+}
+
+// displayBuildOutput prints a build stream to stdout.
+func displayBuildOutput(r io.Reader) error {
+	return DisplayBuildOutput(r, os.Stdout)
+}
+
+type buildOutput struct {
+	out io.Writer
+	// step is the current classic builder step.
+	step string
+	// tail holds the last lines of classic builder output.
+	tail    []string
+	partial string
+
+	vertices map[string]*buildVertex
+	next     int
+	failed   *buildVertex
+}
+
+// buildVertex is one BuildKit build step.
+type buildVertex struct {
+	num     int
+	name    string
+	started bool
+	done    bool
+	err     string
+	tail    []string
+	partial string
+}
+
+func (b *buildOutput) println(line string) {
+	fmt.Fprintln(b.out, line)
+	b.tail = appendTail(b.tail, line)
+}
+
+// stream prints classic builder output, which arrives in arbitrary chunks,
+// and remembers the current "Step n/m : INSTRUCTION".
+func (b *buildOutput) stream(s string) {
+	fmt.Fprint(b.out, s)
+	text := b.partial + s
+	lines := strings.Split(text, "\n")
+	b.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if strings.HasPrefix(line, "Step ") {
+			if _, instruction, ok := strings.Cut(line, " : "); ok {
+				b.step = instruction
+				b.tail = nil
+				continue
+			}
+		}
+		b.tail = appendTail(b.tail, line)
+	}
+}
+
+// failure builds the BuildError for err, attributing it to the failed
+// BuildKit vertex or the current classic step.
+func (b *buildOutput) failure(err error) error {
+	if b.failed != nil {
+		return &BuildError{Step: b.failed.name, Log: b.failed.tail, Err: err}
+	}
+	tail := b.tail
+	if b.partial != "" {
+		tail = appendTail(tail, b.partial)
+	}
+	return &BuildError{Step: b.step, Log: tail, Err: err}
+}
+
+func appendTail(tail []string, line string) []string {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return tail
+	}
+	tail = append(tail, line)
+	if len(tail) > buildLogLines {
+		tail = tail[len(tail)-buildLogLines:]
+	}
+	return tail
+}
+
+// trace prints one BuildKit StatusResponse in the style of
+// docker build --progress=plain.
+func (b *buildOutput) trace(aux json.RawMessage) error {
+	var data []byte
+	if err := json.Unmarshal(aux, &data); err != nil {
+		return fmt.Errorf("invalid BuildKit trace: %w", err)
+	}
+	status, err := parseBuildkitStatus(data)
+	if err != nil {
+		return fmt.Errorf("invalid BuildKit trace: %w", err)
+	}
+	for _, v := range status.vertices {
+		vertex := b.vertex(v.digest)
+		if v.name != "" {
+			vertex.name = v.name
+		}
+		if !vertex.started && (v.started || v.cached) {
+			vertex.started = true
+			fmt.Fprintf(b.out, "#%d %s\n", vertex.num, vertex.name)
+		}
+		if v.err != "" && vertex.err == "" {
+			vertex.err = v.err
+			b.failed = vertex
+			fmt.Fprintf(b.out, "#%d ERROR: %s\n", vertex.num, v.err)
+		} else if !vertex.done && v.cached {
+			vertex.done = true
+			fmt.Fprintf(b.out, "#%d CACHED\n", vertex.num)
+		} else if !vertex.done && v.completed {
+			vertex.done = true
+			fmt.Fprintf(b.out, "#%d DONE\n", vertex.num)
+		}
+	}
+	for _, l := range status.logs {
+		vertex := b.vertex(l.vertex)
+		lines := strings.Split(vertex.partial+string(l.msg), "\n")
+		vertex.partial = lines[len(lines)-1]
+		for _, line := range lines[:len(lines)-1] {
+			fmt.Fprintf(b.out, "#%d %s\n", vertex.num, line)
+			vertex.tail = appendTail(vertex.tail, line)
+		}
+	}
+	return nil
+}
+
+func (b *buildOutput) vertex(digest string) *buildVertex {
+	v, ok := b.vertices[digest]
+	if !ok {
+		b.next++
+		v = &buildVertex{num: b.next}
+		b.vertices[digest] = v
+	}
+	return v
+}
+
+// buildkitStatus holds the parts of a BuildKit StatusResponse that are shown.
+type buildkitStatus struct {
+	vertices []buildkitVertex
+	logs     []buildkitLog
+}
+
+type buildkitVertex struct {
+	digest    string
+	name      string
+	cached    bool
+	started   bool
+	completed bool
+	err       string
+}
+
+type buildkitLog struct {
+	vertex string
+	msg    []byte
+}
+
+// parseBuildkitStatus decodes the vertexes (field 1) and logs (field 3) of
+// a moby.buildkit.v1.StatusResponse.
+func parseBuildkitStatus(data []byte) (buildkitStatus, error) {
+	var status buildkitStatus
+	err := consumeFields(data, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			var v buildkitVertex
+			err := consumeFields(value, func(num protowire.Number, value []byte) error {
+				switch num {
+				case 1:
+					v.digest = string(value)
+				case 3:
+					v.name = string(value)
+				case 4:
+					v.cached = len(value) > 0 && value[0] != 0
+				case 5:
+					v.started = true
+				case 6:
+					v.completed = true
+				case 7:
+					v.err = string(value)
+				}
+				return nil
+			})
+			status.vertices = append(status.vertices, v)
+			return err
+		case 3:
+			var l buildkitLog
+			err := consumeFields(value, func(num protowire.Number, value []byte) error {
+				switch num {
+				case 1:
+					l.vertex = string(value)
+				case 4:
+					l.msg = value
+				}
+				return nil
+			})
+			status.logs = append(status.logs, l)
+			return err
+		}
+		return nil
+	})
+	return status, err
+}
+
+// consumeFields calls fn for each field of a protobuf message. Bytes fields
+// are passed as is and varints as their single-byte truth value; other
+// wire types are skipped.
+func consumeFields(data []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var value []byte
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(data)
+		case protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(data)
+			if v != 0 {
+				value = []byte{1}
+			} else {
+				value = []byte{0}
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if value != nil {
+			if err := fn(num, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package dockerinternal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/moby/moby/client"
+)
+
+// NeedsBuildx reports whether cfg uses cache options the Engine API build
+// endpoint cannot handle. The endpoint only imports cache from images; cache
+// export and local directory caches need docker buildx.
+func NeedsBuildx(cfg BuildConfig) bool {
+	return len(cfg.CacheTo) > 0 || slices.ContainsFunc(cfg.CacheFrom, func(spec string) bool {
+		return strings.Contains(spec, "=")
+	})
+}
+
+// buildWithBuildx runs docker buildx build for options in dir. The image is
+// loaded into the local image store like an API build.
+func buildWithBuildx(ctx context.Context, dir string, options client.ImageBuildOptions, cfg BuildConfig) error {
+	docker, err := exec.LookPath("docker")
+	if err != nil {
+		return fmt.Errorf("--cache-to and local --cache-from need the docker CLI with buildx: %w", err)
+	}
+	if exports := cacheExports(cfg.CacheTo); len(exports) > 0 {
+		// The default docker driver only exports inline cache.
+		driver, err := buildxDriver(ctx, docker)
+		if err != nil {
+			return err
+		}
+		if driver == "docker" {
+			return fmt.Errorf("--cache-to %s needs a buildx builder with the docker-container driver, but the current builder uses the docker driver; create one with 'docker buildx create --use --driver docker-container'", exports[0])
+		}
+	}
+	args := buildxArgs(dir, options, cfg)
+	fmt.Printf("Running docker %s\n", strings.Join(args, " "))
+
+	tail := &outputTail{}
+	stdout, stderr := tail.Writer(), tail.Writer()
+	cmd := exec.CommandContext(ctx, docker, args...)
+	cmd.Stdout = io.MultiWriter(os.Stdout, stdout)
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	err = cmd.Run()
+	stdout.Close()
+	stderr.Close()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &BuildError{Log: tail.Lines(), Err: fmt.Errorf("docker buildx build exited with status %d", exitErr.ExitCode())}
+		}
+		return fmt.Errorf("error running docker buildx build: %w", err)
+	}
+	return nil
+}
+
+// cacheExports returns the --cache-to specs other than type=inline.
+func cacheExports(specs []string) []string {
+	var exports []string
+	for _, spec := range specs {
+		if !slices.Contains(strings.Split(spec, ","), "type=inline") {
+			exports = append(exports, spec)
+		}
+	}
+	return exports
+}
+
+// buildxDriver returns the driver of the current buildx builder, e.g.
+// docker or docker-container.
+func buildxDriver(ctx context.Context, docker string) (string, error) {
+	out, err := exec.CommandContext(ctx, docker, "buildx", "inspect").Output()
+	if err != nil {
+		return "", fmt.Errorf("error inspecting the buildx builder: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if driver, ok := strings.CutPrefix(line, "Driver:"); ok {
+			return strings.TrimSpace(driver), nil
+		}
+	}
+	return "", nil
+}
+
+func buildxArgs(dir string, options client.ImageBuildOptions, cfg BuildConfig) []string {
+	args := []string{"buildx", "build", "--progress=plain", "--load", "--file", options.Dockerfile}
+	if options.Target != "" {
+		args = append(args, "--target", options.Target)
+	}
+	for _, tag := range options.Tags {
+		args = append(args, "--tag", tag)
+	}
+	for _, name := range sortedKeys(options.BuildArgs) {
+		// DOCKER_BUILDKIT only selects the builder for API builds.
+		if name == "DOCKER_BUILDKIT" || options.BuildArgs[name] == nil {
+			continue
+		}
+		args = append(args, "--build-arg", name+"="+*options.BuildArgs[name])
+	}
+	for _, name := range sortedKeys(options.Labels) {
+		args = append(args, "--label", name+"="+options.Labels[name])
+	}
+	if options.NoCache {
+		args = append(args, "--no-cache")
+	}
+	for _, spec := range cfg.CacheFrom {
+		args = append(args, "--cache-from", spec)
+	}
+	for _, spec := range cfg.CacheTo {
+		args = append(args, "--cache-to", spec)
+	}
+	return append(args, dir)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// outputTail keeps the last buildLogLines lines of the docker CLI's stdout
+// and stderr, which are copied concurrently.
+type outputTail struct {
+	mu   sync.Mutex
+	tail []string
+}
+
+// Writer returns a writer for one stream. Each stream keeps its own partial
+// line so the two are not spliced together.
+func (t *outputTail) Writer() *tailWriter {
+	return &tailWriter{t: t}
+}
+
+// Lines returns the tail.
+func (t *outputTail) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.tail)
+}
+
+type tailWriter struct {
+	t       *outputTail
+	partial string
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	lines := strings.Split(w.partial+string(p), "\n")
+	w.partial = lines[len(lines)-1]
+	w.t.mu.Lock()
+	defer w.t.mu.Unlock()
+	for _, line := range lines[:len(lines)-1] {
+		w.t.tail = appendTail(w.t.tail, line)
+	}
+	return len(p), nil
+}
+
+// Close adds an unterminated last line to the tail.
+func (w *tailWriter) Close() error {
+	w.t.mu.Lock()
+	defer w.t.mu.Unlock()
+	w.t.tail = appendTail(w.t.tail, w.partial)
+	w.partial = ""
+	return nil
+}
@@ -1,17 +1,20 @@
 package dockerinternal
 
 import (
-	"archive/tar"
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/netip"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"slices"
 	"strings"
+	"time"
 
+	"fortihugorunner/version"
+	"github.com/containerd/errdefs"
 	"github.com/moby/moby/api/types/build"
 	"github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/api/types/mount"
@@ -22,10 +25,17 @@ import (
 type ServerConfig struct {
 	DockerImage   string
 	HostPort      string
+	BindAddress   netip.Addr
 	ContainerPort string
 	WatchDir      string
 	MountToml     bool
 	PullLatest    bool
+	WatchMode     WatchMode
+	WatchIgnore   []string
+	WatchBackend  WatchBackend
+	PollInterval  time.Duration
+	ReadyTimeout  time.Duration
+	LogLines      int
 }
 
 type ContentConfig struct {
@@ -33,32 +43,69 @@ type ContentConfig struct {
 	// Add other flags as needed.
 }
 
-func LocalImageCheck(image string, tag string, cli *client.Client, imageName string) error {
+// LocalImageCheck compares the local repo digest of remote with the
+// registry's and pulls remote when they differ. The pulled image is also
+// tagged as localName, e.g. fortinet-hugo:latest. For multi-arch images the
+// entry for the Docker daemon's platform is reported.
+func LocalImageCheck(cli ImageClient, reg *Registry, remote string, localName string) error {
 
 	ctx := context.Background()
-	imageWithTag := image + ":" + tag
-
-	fmt.Printf("Checking for %s local/remote repo digest match...\n", imageWithTag)
-	remoteDigest, err := getRemoteDigest(image, tag)
+	ref, err := ParseImageRef(remote)
 	if err != nil {
 		return err
 	}
-	fmt.Println("Remote Digest:", remoteDigest)
 
-	localDigest, err := getLocalRepoDigest(cli, image)
+	// The image a launch will run: localName when the pull is retagged.
+	image := remote
+	if localName != "" {
+		image = localName
+	}
+
+	fmt.Printf("Checking for %s local/remote repo digest match...\n", remote)
+	manifest, checkedAt, cached := reg.Cache.Get(ref.String())
+	if cached {
+		fmt.Printf("Remote Digest: %s (checked %s ago; use --refresh to check now)\n", manifest.Digest, time.Since(checkedAt).Round(time.Second))
+	} else {
+		manifest, err = reg.Manifest(ctx, ref)
+		if err != nil {
+			if !IsRegistryUnreachable(err) {
+				return err
+			}
+			fmt.Printf("Warning: cannot reach %s, skipping the update check: %v\n", ref.Domain, err)
+			return RequireLocalImage(cli, image)
+		}
+		if err := reg.Cache.Put(ref.String(), manifest); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		fmt.Println("Remote Digest:", manifest.Digest)
+	}
+
+	platform := DaemonPlatform(ctx, cli)
+	if manifest.IsIndex() {
+		if entry, ok := manifest.ForPlatform(platform); ok {
+			fmt.Printf("Remote %s image: %s\n", entry.Platform, entry.Digest)
+		} else {
+			fmt.Printf("Warning: %s has no %s image (available: %s)\n", remote, platform, strings.Join(manifest.Platforms(), ", "))
+		}
+	}
+
+	localDigests, err := getLocalRepoDigests(cli, ref)
 	if err != nil {
 		fmt.Println("Local image not found or no digest found.")
-		localDigest = ""
 	} else {
-		fmt.Println("Local RepoDigest:", localDigest)
+		fmt.Println("Local RepoDigest:", strings.Join(localDigests, ", "))
 	}
-	if localDigest != remoteDigest {
+	if !slices.ContainsFunc(localDigests, func(d string) bool { return manifest.Matches(d, platform) }) {
 		fmt.Println("Update needed → pulling image...")
-		if err := EnsureImagePulled(cli, imageWithTag); err != nil {
-			fmt.Println("Failed to pull image:", err)
-		} else {
+		if err := EnsureImagePulled(cli, remote); err != nil {
+			if !IsPullUnreachable(err) {
+				return err
+			}
+			fmt.Printf("Warning: cannot pull %s, keeping the local image: %v\n", remote, err)
+			return RequireLocalImage(cli, image)
+		} else if localName != "" && localName != remote {
 			fmt.Println("Image updated successfully, retagging...")
-			_, err = cli.ImageTag(ctx, client.ImageTagOptions{Source: imageWithTag, Target: imageName + ":" + tag})
+			_, err = cli.ImageTag(ctx, client.ImageTagOptions{Source: remote, Target: localName})
 			if err != nil {
 				fmt.Printf("Error re-tagging image: %v\n", err)
 				return err
@@ -70,71 +117,54 @@ func LocalImageCheck(image string, tag string, cli *client.Client, imageName str
 	return nil
 }
 
-func getRemoteDigest(image string, tag string) (string, error) {
-	parts := strings.SplitN(image, "/", 3)
-	if len(parts) < 3 {
-		return "", fmt.Errorf("expected image format: public.ecr.aws/<namespace>/<repo>")
-	}
-
-	registry := parts[0]
-	namespaceRepo := parts[1] + "/" + parts[2]
-	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, namespaceRepo, tag)
-
-	digest, err := fetchManifestDigestWithToken(manifestURL, "")
-	if err == nil {
-		return digest, nil
-	}
-
-	// If unauthorized, try to fetch token
-	if !strings.Contains(err.Error(), "401") {
-		return "", err
+// ErrNoLocalImage is returned when an image is needed offline but does not
+// exist locally.
+var ErrNoLocalImage = errors.New("image not available locally")
+
+// RequireLocalImage returns nil if image exists locally, and otherwise an
+// error wrapping ErrNoLocalImage. It is used when the registry cannot be
+// reached and the local image is all there is.
+func RequireLocalImage(cli client.ImageAPIClient, image string) error {
+	if _, err := cli.ImageInspect(context.Background(), image); err != nil {
+		if errdefs.IsNotFound(err) {
+			return fmt.Errorf("%w: %s; connect to the network and run pull-image first", ErrNoLocalImage, image)
+		}
+		return err
 	}
+	fmt.Printf("Using local image %s.\n", image)
+	return nil
+}
 
-	// Fetch token from WWW-Authenticate header
-	token, tokenErr := getRegistryToken(manifestURL)
-	if tokenErr != nil {
-		return "", fmt.Errorf("failed to get registry token: %v", tokenErr)
+// DaemonPlatform returns the Docker daemon's OS and architecture, falling
+// back to this machine's if the daemon cannot be asked.
+func DaemonPlatform(ctx context.Context, cli ImageClient) Platform {
+	v, err := cli.ServerVersion(ctx, client.ServerVersionOptions{})
+	if err != nil || v.Os == "" || v.Arch == "" {
+		return Platform{OS: "linux", Architecture: runtime.GOARCH}
 	}
-
-	return fetchManifestDigestWithToken(manifestURL, token)
+	return Platform{OS: v.Os, Architecture: v.Arch}
 }
 
-func getLocalRepoDigest(cli *client.Client, image string) (string, error) {
+// getLocalRepoDigests returns the digests the local image was pulled with
+// from ref's repository.
+func getLocalRepoDigests(cli client.ImageAPIClient, ref ImageRef) ([]string, error) {
 	ctx := context.Background()
-	imgInspect, err := cli.ImageInspect(ctx, image)
+	imgInspect, err := cli.ImageInspect(ctx, ref.String())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	for _, digest := range imgInspect.RepoDigests {
-		if strings.HasPrefix(digest, image+"@") {
-			return strings.SplitN(digest, "@", 2)[1], nil
+	var digests []string
+	for _, repoDigest := range imgInspect.RepoDigests {
+		local, err := ParseImageRef(repoDigest)
+		if err == nil && local.Name() == ref.Name() && local.Digest != "" {
+			digests = append(digests, local.Digest)
 		}
 	}
-
-	return "", fmt.Errorf("no matching RepoDigest found for image: %s", image)
-}
-
-func extractBranchByStage(dockerfile string, stage string) (string, error) {
-	lines := strings.Split(dockerfile, "\n")
-
-	stageHeader := fmt.Sprintf("FROM base as %s", stage)
-	inTargetStage := false
-	re := regexp.MustCompile(`(?i)^ADD\s+https?://[^#]+#([^ \n]+)`)
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(strings.ToUpper(line), "FROM ") {
-			inTargetStage = strings.EqualFold(line, stageHeader)
-		}
-		if inTargetStage {
-			if match := re.FindStringSubmatch(line); match != nil {
-				return match[1], nil
-			}
-		}
+	if len(digests) == 0 {
+		return nil, fmt.Errorf("no matching RepoDigest found for image: %s", ref.Name())
 	}
-
-	return "", fmt.Errorf("no branch found in Dockerfile")
+	return digests, nil
 }
 
 func EnsureImagePulled(cli client.ImageAPIClient, imageName string) error {
@@ -142,96 +172,241 @@ func EnsureImagePulled(cli client.ImageAPIClient, imageName string) error {
 
 	fmt.Printf("Ensuring required image %s is available...\n", imageName)
 
-	out, err := cli.ImagePull(ctx, imageName, client.ImagePullOptions{})
+	out, err := cli.ImagePull(ctx, imageName, client.ImagePullOptions{RegistryAuth: registryAuth(imageName)})
 	if err != nil {
 		return fmt.Errorf("failed to pull required image %s: %w", imageName, err)
 	}
 	defer out.Close()
 
-	// Stream output to the console
-	_, err = io.Copy(os.Stdout, out)
-	if err != nil {
-		return fmt.Errorf("error reading image pull output: %w", err)
+	if err := displayStream(out); err != nil {
+		return fmt.Errorf("failed to pull required image %s: %w", imageName, err)
 	}
 
-	fmt.Printf("%s image pulled successfully", imageName)
+	fmt.Printf("%s image pulled successfully\n", imageName)
 	return nil
 }
 
-// buildDockerImage builds the Docker image using the SDK
-func BuildDockerImage(cli *client.Client, imageName string, target string, envArg string, hugoVersion string) error {
-
-	content, err := os.ReadFile("Dockerfile")
-	if err != nil {
-		return fmt.Errorf("Can't find Dockerfile...")
+// PullAndTag pulls ref and tags it locally as target, e.g. the ECR image as
+// plain fortinet-hugo.
+func PullAndTag(cli client.ImageAPIClient, ref string, target string) error {
+	if err := EnsureImagePulled(cli, ref); err != nil {
+		return err
 	}
-	branchWorking, err := extractBranchByStage(string(content), target)
-	if err != nil {
-		return fmt.Errorf("Branch not found: %w", err)
+	if _, err := cli.ImageTag(context.Background(), client.ImageTagOptions{Source: ref, Target: target}); err != nil {
+		return fmt.Errorf("error re-tagging image: %w", err)
 	}
+	return nil
+}
 
-	cli, err = NewDockerClient()
-	if err != nil {
-		panic(fmt.Errorf("failed to create Docker client: %w", err))
-	}
+// BuildConfig describes a build-image run.
+type BuildConfig struct {
+	ImageName string
+	// Tags are additional names for the image.
+	Tags   []string
+	Target string
+	// HugoVersion is passed as the HUGO_VERSION build arg unless empty.
+	HugoVersion string
+	// Dockerfile is the path of the Dockerfile inside the build context.
+	// It defaults to "Dockerfile".
+	Dockerfile string
+	// BuildArgs are passed to the build on top of the runner's own. A nil
+	// value takes the variable from the environment, as docker build does.
+	BuildArgs map[string]*string
+	Labels    map[string]string
+	// PruneCache removes the build cache left by earlier runner builds
+	// before building. Attribution is best effort, see RecordBuildCache.
+	PruneCache bool
+	NoCache    bool
+	// CacheFrom lists images to use as cache sources, or buildx cache
+	// specs such as type=local,src=.buildcache.
+	CacheFrom []string
+	// CacheTo lists buildx cache export specs such as
+	// type=local,dest=.buildcache.
+	CacheTo []string
+}
 
-	images := []string{
-		"docker/dockerfile:1.5-labs",
-		"docker.io/hugomods/hugo:" + hugoVersion,
+// ParseKeyValues parses repeatable KEY=VALUE flags such as --build-arg and
+// --label. A bare KEY maps to nil.
+func ParseKeyValues(flag string, values []string) (map[string]*string, error) {
+	parsed := make(map[string]*string, len(values))
+	for _, kv := range values {
+		key, value, ok := strings.Cut(kv, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid --%s %q: expected KEY=VALUE", flag, kv)
+		}
+		if ok {
+			parsed[key] = &value
+		} else {
+			parsed[key] = nil
+		}
 	}
+	return parsed, nil
+}
 
-	for _, img := range images {
-		if err := EnsureImagePulled(cli, img); err != nil {
-			panic(err)
+// BuildArgValues returns the build args of cfg: HUGO_VERSION from
+// HugoVersion, overridden by BuildArgs. Args without a value take it from the
+// environment and are left out if it is not set.
+func (cfg BuildConfig) BuildArgValues() map[string]string {
+	values := map[string]string{}
+	if cfg.HugoVersion != "" {
+		values[HugoVersionArg] = cfg.HugoVersion
+	}
+	for key, value := range cfg.BuildArgs {
+		if value != nil {
+			values[key] = *value
+		} else if env, ok := os.LookupEnv(key); ok {
+			values[key] = env
 		}
 	}
+	return values
+}
+
+// buildDockerImage builds the Docker image using the SDK
+func BuildDockerImage(cli DockerClient, cfg BuildConfig) error {
+	dockerfile := cfg.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
 
-	// Create a tarball of the current directory (Docker build context)
-	tarBuffer, err := CreateTarball(".")
+	if _, err := os.Stat(dockerfile); err != nil {
+		return fmt.Errorf("Can't find %s...", dockerfile)
+	}
+	buildArgs := cfg.BuildArgValues()
+	// The build does not depend on the parse; BuildKit reads the Dockerfile
+	// itself. A Dockerfile this parser does not understand only leaves the
+	// branch and Hugo version unknown.
+	var branchWorking string
+	df, err := LoadDockerfile(dockerfile)
 	if err != nil {
-		return fmt.Errorf("error creating tarball: %w", err)
+		fmt.Printf("Warning: %v; the CentralRepo branch is unknown\n", err)
+		df = nil
+	} else {
+		sources, err := df.Sources(cfg.Target, buildArgs)
+		if err != nil {
+			return err
+		}
+		branchWorking = CentralRepoBranch(sources)
 	}
 
 	// Define build options
 	options := client.ImageBuildOptions{
-		Tags:       []string{imageName},
-		Dockerfile: "Dockerfile",
-		Target:     target,
+		Tags:       append([]string{cfg.ImageName}, cfg.Tags...),
+		Dockerfile: filepath.ToSlash(filepath.Clean(dockerfile)),
+		Target:     cfg.Target,
 		Remove:     true,
+		NoCache:    cfg.NoCache,
+		CacheFrom:  cfg.CacheFrom,
 		Version:    build.BuilderBuildKit,
-		//CacheFrom: []string{"type=registry,ref=docker/dockerfile:1.5-labs"},
 		BuildArgs: map[string]*string{
 			"BUILDKIT_INLINE_CACHE": strPtr("1"),
 			"DOCKER_BUILDKIT":       strPtr("1"),
 		},
+		Labels: map[string]string{},
+	}
+	for key, value := range buildArgs {
+		options.BuildArgs[key] = strPtr(value)
+	}
+	for key, value := range cfg.Labels {
+		options.Labels[key] = value
+	}
+	// The version label identifies runner-built images for prune.
+	options.Labels[LabelVersion] = version.Version
+
+	images := []string{"docker/dockerfile:1.5-labs"}
+	if hugoVersion, ok := buildArgs[HugoVersionArg]; ok {
+		if df != nil {
+			for _, warning := range df.HugoVersionWarnings(cfg.Target, hugoVersion) {
+				fmt.Printf("Warning: %s\n", warning)
+			}
+		}
+		options.Labels[LabelHugoVersion] = hugoVersion
+	}
+	// The Hugo version the image really gets is the hugomods/hugo tag it
+	// builds on.
+	if df != nil {
+		if base, ok := df.HugoBase(cfg.Target, buildArgs); ok {
+			images = append(images, base.Image)
+			options.Labels[LabelHugoVersion] = base.Tag
+		}
+	}
+
+	for _, img := range images {
+		if err := EnsureImagePulled(cli, img); err != nil {
+			return err
+		}
 	}
 
-	// Execute Docker build
 	ctx := context.Background()
-	_, err = cli.BuildCachePrune(ctx, client.BuildCachePruneOptions{})
+	if cfg.PruneCache {
+		records, err := RunnerBuildCache(ctx, cli)
+		if err != nil {
+			return err
+		}
+		removed, reclaimed, err := PruneRunnerBuildCache(ctx, cli, records)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		fmt.Printf("Pruned %d build cache records from earlier builds (%s).\n", removed, FormatBytes(int64(reclaimed)))
+	}
+
+	// Remember which cache records this build creates so prune can find them.
+	cacheBefore, cacheErr := BuildCacheIDs(ctx, cli)
 
-	response, err := cli.ImageBuild(ctx, tarBuffer, options)
+	if NeedsBuildx(cfg) {
+		err = buildWithBuildx(ctx, ".", options, cfg)
+	} else {
+		err = buildWithAPI(ctx, cli, options)
+	}
 	if err != nil {
-		return fmt.Errorf("error building image: %w", err)
+		return err
 	}
-	defer response.Body.Close()
 
-	// Stream build output
-	_, err = io.Copy(os.Stdout, response.Body)
-	if err != nil {
-		return fmt.Errorf("error reading build output: %w", err)
+	if cacheErr == nil {
+		if err := RecordBuildCache(ctx, cli, cacheBefore); err != nil {
+			fmt.Printf("Warning: could not record build cache: %v\n", err)
+		}
 	}
 
-	fmt.Println("Image built with CentralRepo branch: ", branchWorking)
+	if branchWorking != "" {
+		fmt.Println("Image built with CentralRepo branch: ", branchWorking)
+	}
 
 	return nil
 }
 
+// buildWithAPI streams the current directory to the daemon's build endpoint.
+func buildWithAPI(ctx context.Context, cli DockerClient, options client.ImageBuildOptions) error {
+	buildContext, err := CreateTarball(".", options.Dockerfile)
+	if err != nil {
+		return fmt.Errorf("error creating build context: %w", err)
+	}
+	defer buildContext.Close()
+
+	response, err := cli.ImageBuild(ctx, buildContext, options)
+	if err != nil {
+		return fmt.Errorf("error building image: %w", err)
+	}
+	defer response.Body.Close()
+
+	return displayBuildOutput(response.Body)
+}
+
 func strPtr(s string) *string {
 	return &s
 }
 
-func StartContainer(ctx context.Context, cli *client.Client, cfg ServerConfig) (string, error) {
+// serverCommand is the hugo server command line. Hugo writes its own port
+// into baseURL and the livereload script, so when the host publishes another
+// port, e.g. after --port-fallback, both are pointed at the host port.
+func serverCommand(cfg ServerConfig) []string {
+	cmd := []string{"server", "--bind", "0.0.0.0"}
+	if cfg.HostPort != cfg.ContainerPort {
+		cmd = append(cmd, "--liveReloadPort", cfg.HostPort, "--baseURL", ServerURL(cfg), "--appendPort=false")
+	}
+	return cmd
+}
+
+func StartContainer(ctx context.Context, cli ContainerClient, cfg ServerConfig) (string, error) {
 	// Adjust the path for mounting.
 	userRepoPath := AdjustPathForDocker(cfg.WatchDir)
 	mounts := []mount.Mount{
@@ -262,9 +437,10 @@ func StartContainer(ctx context.Context, cli *client.Client, cfg ServerConfig) (
 	}
 
 	containerConfig := &container.Config{
-		Image: cfg.DockerImage,
-		Cmd:   []string{"server", "--bind", "0.0.0.0"},
-		Tty:   true,
+		Image:  cfg.DockerImage,
+		Cmd:    serverCommand(cfg),
+		Tty:    true,
+		Labels: sessionLabels(cfg),
 		ExposedPorts: network.PortSet{
 			containerPort: struct{}{},
 		},
@@ -274,7 +450,7 @@ func StartContainer(ctx context.Context, cli *client.Client, cfg ServerConfig) (
 		PortBindings: network.PortMap{
 			containerPort: []network.PortBinding{
 				{
-					HostIP:   netip.IPv4Unspecified(),
+					HostIP:   cfg.BindAddress,
 					HostPort: cfg.HostPort,
 				},
 			},
@@ -282,6 +458,7 @@ func StartContainer(ctx context.Context, cli *client.Client, cfg ServerConfig) (
 	}
 
 	created, err := cli.ContainerCreate(ctx, client.ContainerCreateOptions{
+		Name:       ContainerName(cfg.WatchDir),
 		Config:     containerConfig,
 		HostConfig: hostConfig,
 	})
@@ -295,7 +472,7 @@ func StartContainer(ctx context.Context, cli *client.Client, cfg ServerConfig) (
 	return created.ID, nil
 }
 
-func AttachContainer(ctx context.Context, cli *client.Client, containerID string) error {
+func AttachContainer(ctx context.Context, cli ContainerClient, containerID string) error {
 	opts := client.ContainerAttachOptions{
 		Stream: true,
 		Stdout: true,
@@ -315,59 +492,55 @@ func AttachContainer(ctx context.Context, cli *client.Client, containerID string
 	return nil
 }
 
-func StopAndRemoveContainer(cli *client.Client, containerID string) {
-	fmt.Printf("Stopping container: %s\n", containerID)
-	timeout := 10
-	stopOpts := client.ContainerStopOptions{Timeout: &timeout}
-	if _, err := cli.ContainerStop(context.Background(), containerID, stopOpts); err != nil {
-		fmt.Printf("Error stopping container %s: %v\n", containerID, err)
+// RebuildInContainer runs a Hugo build inside the running container via exec
+// and streams its output to the console.
+func RebuildInContainer(ctx context.Context, cli ContainerClient, containerID string) error {
+	exec, err := cli.ExecCreate(ctx, containerID, client.ExecCreateOptions{
+		Cmd:          []string{"hugo"},
+		TTY:          true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("exec create error: %w", err)
 	}
-	if _, err := cli.ContainerRemove(context.Background(), containerID, client.ContainerRemoveOptions{Force: true}); err != nil {
-		fmt.Printf("Error removing container %s: %v\n", containerID, err)
+	resp, err := cli.ExecAttach(ctx, exec.ID, client.ExecAttachOptions{TTY: true})
+	if err != nil {
+		return fmt.Errorf("exec attach error: %w", err)
 	}
-}
-
-// createTarball creates a tar archive of the given directory
-func CreateTarball(dir string) (io.Reader, error) {
-	buf := new(bytes.Buffer)
-	tw := tar.NewWriter(buf)
-	defer tw.Close()
-
-	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	defer resp.Close()
+	_, _ = io.Copy(os.Stdout, resp.Reader)
 
-		// Skip directories
-		if fi.IsDir() {
-			return nil
-		}
+	inspect, err := cli.ExecInspect(ctx, exec.ID, client.ExecInspectOptions{})
+	if err != nil {
+		return fmt.Errorf("exec inspect error: %w", err)
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("hugo build exited with code %d", inspect.ExitCode)
+	}
+	return nil
+}
 
-		// Open the file
-		f, err := os.Open(file)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+func StopAndRemoveContainer(cli ContainerClient, containerID string) {
+	fmt.Printf("Stopping container: %s\n", containerID)
+	if err := RemoveContainer(context.Background(), cli, containerID); err != nil {
+		fmt.Printf("Error removing container %s: %v\n", containerID, err)
+	}
+}
 
-		// Write file header
-		header, err := tar.FileInfoHeader(fi, file)
-		if err != nil {
-			return err
-		}
-		header.Name = file // Preserve file path
-		if err := tw.WriteHeader(header); err != nil {
-			return err
+// RemoveContainer gracefully stops a container and then force-removes it,
+// without printing progress. A failed stop is only reported when the forced
+// removal fails too.
+func RemoveContainer(ctx context.Context, cli ContainerClient, containerID string) error {
+	timeout := 10
+	stopOpts := client.ContainerStopOptions{Timeout: &timeout}
+	_, stopErr := cli.ContainerStop(ctx, containerID, stopOpts)
+	if _, err := cli.ContainerRemove(ctx, containerID, client.ContainerRemoveOptions{Force: true}); err != nil {
+		if stopErr != nil {
+			return fmt.Errorf("%w (stop failed: %v)", err, stopErr)
 		}
-
-		// Copy file data to the tar writer
-		_, err = io.Copy(tw, f)
 		return err
-	})
-
-	if err != nil {
-		return nil, err
 	}
-
-	return bytes.NewReader(buf.Bytes()), nil
+	removeLease(containerID)
+	return nil
 }
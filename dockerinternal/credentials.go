@@ -0,0 +1,214 @@
+package dockerinternal
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/moby/moby/api/types/registry"
+)
+
+// dockerHubServerAddress is the key the docker CLI stores Docker Hub
+// credentials under, in config.json and in credential helpers.
+const dockerHubServerAddress = "https://index.docker.io/v1/"
+
+// credentialHelperTimeout bounds a docker-credential-* invocation, which may
+// wait on a keychain prompt.
+const credentialHelperTimeout = 30 * time.Second
+
+// CredentialStore reads registry credentials the way the docker CLI does:
+// from a credential helper configured for the registry in credHelpers, from
+// the default credsStore helper, or from the base64 auths entries of
+// config.json.
+type CredentialStore struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// LoadCredentialStore reads config.json from the docker config directory. A
+// missing file gives an empty store, which means anonymous access.
+func LoadCredentialStore() (*CredentialStore, error) {
+	configPath := filepath.Join(dockerConfigDir(), "config.json")
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &CredentialStore{}, nil
+		}
+		return nil, fmt.Errorf("failed to read docker config: %w", err)
+	}
+	var store CredentialStore
+	if err := json.Unmarshal(content, &store); err != nil {
+		return nil, fmt.Errorf("failed to parse docker config: %w", err)
+	}
+	return &store, nil
+}
+
+// serverAddress returns the key credentials for domain are stored under.
+func serverAddress(domain string) string {
+	if domain == "docker.io" || domain == "index.docker.io" || domain == dockerHubRegistry {
+		return dockerHubServerAddress
+	}
+	return domain
+}
+
+// normalizeAuthKey reduces a config.json auths key such as
+// "https://ghcr.io/v1/" to its host.
+func normalizeAuthKey(key string) string {
+	if key == dockerHubServerAddress {
+		return key
+	}
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host, _, _ := strings.Cut(key, "/")
+	return serverAddress(host)
+}
+
+// Lookup returns the credentials for a registry domain such as ghcr.io or
+// docker.io. No credentials gives an empty AuthConfig.
+func (s *CredentialStore) Lookup(domain string) (registry.AuthConfig, error) {
+	address := serverAddress(domain)
+	if helper := s.CredHelpers[domain]; helper != "" {
+		return runCredentialHelper(helper, address)
+	}
+	if helper := s.CredHelpers[address]; helper != "" {
+		return runCredentialHelper(helper, address)
+	}
+	if s.CredsStore != "" {
+		return runCredentialHelper(s.CredsStore, address)
+	}
+	for key, entry := range s.Auths {
+		if normalizeAuthKey(key) != address {
+			continue
+		}
+		auth := registry.AuthConfig{ServerAddress: address, IdentityToken: entry.IdentityToken}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return registry.AuthConfig{}, fmt.Errorf("invalid auth for %s in docker config: %w", key, err)
+			}
+			user, pass, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return registry.AuthConfig{}, fmt.Errorf("invalid auth for %s in docker config", key)
+			}
+			auth.Username, auth.Password = user, pass
+		}
+		return auth, nil
+	}
+	return registry.AuthConfig{}, nil
+}
+
+// Credentials returns the username and password for a registry domain and
+// fits Registry.Credentials. Identity tokens are only understood by the
+// Docker daemon, so they give anonymous access here.
+func (s *CredentialStore) Credentials(domain string) (string, string, error) {
+	auth, err := s.Lookup(domain)
+	if err != nil {
+		return "", "", err
+	}
+	if auth.IdentityToken != "" {
+		return "", "", nil
+	}
+	return auth.Username, auth.Password, nil
+}
+
+// RegistryAuth returns the encoded X-Registry-Auth value for pulling image,
+// or "" when there are no credentials for its registry.
+func (s *CredentialStore) RegistryAuth(image string) (string, error) {
+	ref, err := ParseImageRef(image)
+	if err != nil {
+		return "", err
+	}
+	auth, err := s.Lookup(ref.Domain)
+	if err != nil {
+		return "", err
+	}
+	if auth.Username == "" && auth.IdentityToken == "" {
+		return "", nil
+	}
+	encoded, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(encoded), nil
+}
+
+// credentialsNotFound is the message helpers print when they have nothing
+// stored for a server.
+const credentialsNotFound = "credentials not found in native keychain"
+
+// runCredentialHelper runs docker-credential-<helper> get for address, as
+// described by the docker-credential-helpers protocol.
+func runCredentialHelper(helper, address string) (registry.AuthConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+
+	program := "docker-credential-" + helper
+	cmd := exec.CommandContext(ctx, program, "get")
+	cmd.Stdin = strings.NewReader(address)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(msg, credentialsNotFound) {
+			return registry.AuthConfig{}, nil
+		}
+		if msg != "" {
+			return registry.AuthConfig{}, fmt.Errorf("%s get %s: %s", program, address, msg)
+		}
+		return registry.AuthConfig{}, fmt.Errorf("%s get %s: %w", program, address, err)
+	}
+
+	var creds struct {
+		ServerURL string `json:"ServerURL"`
+		Username  string `json:"Username"`
+		Secret    string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return registry.AuthConfig{}, fmt.Errorf("invalid output from %s: %w", program, err)
+	}
+	auth := registry.AuthConfig{ServerAddress: address}
+	// Helpers store identity tokens under the username <token>.
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username, auth.Password = creds.Username, creds.Secret
+	}
+	return auth, nil
+}
+
+// registryAuth returns the X-Registry-Auth value for pulling image from the
+// docker config. Credential problems are reported and the pull proceeds
+// anonymously, which still works for public images.
+func registryAuth(image string) string {
+	store, err := LoadCredentialStore()
+	if err == nil {
+		var auth string
+		if auth, err = store.RegistryAuth(image); err == nil {
+			return auth
+		}
+	}
+	fmt.Printf("Warning: no registry credentials for %s: %v\n", image, err)
+	return ""
+}
+
+// dockerCredentials looks up credentials in the docker config on each call,
+// so a docker login during a session is picked up.
+func dockerCredentials(domain string) (string, string, error) {
+	store, err := LoadCredentialStore()
+	if err != nil {
+		return "", "", err
+	}
+	return store.Credentials(domain)
+}
@@ -0,0 +1,113 @@
+package dockerinternal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultDigestCacheTTL is how long a remote digest is trusted before the
+// registry is asked again.
+const DefaultDigestCacheTTL = time.Hour
+
+const digestCacheFile = "digests.json"
+
+// DigestCache remembers what image references resolved to in their registry,
+// so repeated launches do not each need a registry round trip.
+type DigestCache struct {
+	// Path is the JSON file entries are kept in.
+	Path string
+	// TTL is how long an entry is used for. Zero disables the cache.
+	TTL time.Duration
+	// Refresh ignores existing entries; fresh results are still stored.
+	Refresh bool
+}
+
+type digestCacheEntry struct {
+	Manifest  RemoteManifest `json:"manifest"`
+	CheckedAt time.Time      `json:"checkedAt"`
+}
+
+// NewDigestCache returns a cache stored in StateDir, or nil if there is no
+// user cache directory.
+func NewDigestCache(ttl time.Duration) *DigestCache {
+	dir, err := StateDir()
+	if err != nil {
+		return nil
+	}
+	return &DigestCache{Path: filepath.Join(dir, digestCacheFile), TTL: ttl}
+}
+
+// Get returns the cached manifest for ref and when it was fetched, if there
+// is an entry younger than the TTL. A nil cache has no entries.
+func (c *DigestCache) Get(ref string) (*RemoteManifest, time.Time, bool) {
+	if c == nil || c.TTL <= 0 || c.Refresh {
+		return nil, time.Time{}, false
+	}
+	entries, err := c.read()
+	if err != nil {
+		return nil, time.Time{}, false
+	}
+	entry, ok := entries[ref]
+	if !ok || time.Since(entry.CheckedAt) >= c.TTL {
+		return nil, time.Time{}, false
+	}
+	return &entry.Manifest, entry.CheckedAt, true
+}
+
+// Put records m as what ref resolves to now. A nil cache stores nothing.
+func (c *DigestCache) Put(ref string, m *RemoteManifest) error {
+	if c == nil || c.TTL <= 0 {
+		return nil
+	}
+	entries, err := c.read()
+	if err != nil {
+		// Replace an unreadable cache rather than failing every launch.
+		entries = map[string]digestCacheEntry{}
+	}
+	entries[ref] = digestCacheEntry{Manifest: *m, CheckedAt: time.Now()}
+	return c.write(entries)
+}
+
+func (c *DigestCache) read() (map[string]digestCacheEntry, error) {
+	entries := map[string]digestCacheEntry{}
+	data, err := os.ReadFile(c.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return entries, nil
+		}
+		return nil, fmt.Errorf("failed to read digest cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse digest cache: %w", err)
+	}
+	return entries, nil
+}
+
+// write replaces the cache file through a rename, so a concurrent launch
+// never reads a partial file.
+func (c *DigestCache) write(entries map[string]digestCacheEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.Path), digestCacheFile+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write digest cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write digest cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write digest cache: %w", err)
+	}
+	return os.Rename(tmp.Name(), c.Path)
+}
@@ -1,6 +1,7 @@
 package dockerinternal
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,6 +18,31 @@ type dockerContextInfo struct {
 	TLSDir        string
 }
 
+// ContainerClient is the part of the Docker API needed to run and manage a
+// server container. *client.Client satisfies it.
+type ContainerClient interface {
+	client.ContainerAPIClient
+	client.ExecAPIClient
+}
+
+// ImageClient is the part of the Docker API needed to check and pull
+// images: image operations plus the daemon's platform.
+type ImageClient interface {
+	client.ImageAPIClient
+	ServerVersion(ctx context.Context, options client.ServerVersionOptions) (client.ServerVersionResult, error)
+}
+
+// DockerClient is the part of the Docker API the runner uses. *client.Client
+// satisfies it; tests use dockerfake.Client.
+type DockerClient interface {
+	ContainerClient
+	ImageClient
+	client.ImageBuildAPIClient
+	client.SystemAPIClient
+}
+
+var _ DockerClient = (*client.Client)(nil)
+
 // NewDockerClient centralizes docker client initialization so that we can honor
 // Docker contexts in the same way the docker CLI does. We look up the active
 // context's endpoint and, when it defines a Host, temporarily set the
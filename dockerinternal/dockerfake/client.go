@@ -0,0 +1,334 @@
+// Package dockerfake provides an in-memory Docker client for tests. It keeps
+// track of the containers it was asked to create and records every call.
+package dockerfake
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/build"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+// Container is a container held by the fake daemon.
+type Container struct {
+	ID         string
+	Name       string
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	Running    bool
+	ExitCode   int
+	Logs       string
+}
+
+// Client is a fake Docker client. Methods that are not implemented panic
+// through the nil embedded interface.
+type Client struct {
+	client.APIClient
+
+	// OnCreate, if set, is called at the start of every ContainerCreate,
+	// without the client's lock held. Tests use it to act in the middle of
+	// a container operation.
+	OnCreate func(name string)
+
+	// ExecExitCode is the exit code reported for exec'd commands.
+	ExecExitCode int
+
+	// OS and Arch are the daemon platform reported by ServerVersion.
+	OS   string
+	Arch string
+
+	// PullErrors makes ImagePull fail for the given references.
+	PullErrors map[string]error
+	// PullOutput replaces the JSON message stream returned by ImagePull.
+	PullOutput string
+	// BuildErr makes ImageBuild fail.
+	BuildErr error
+	// BuildOutput is the JSON message stream returned by ImageBuild.
+	BuildOutput string
+
+	mu         sync.Mutex
+	calls      []string
+	containers map[string]*Container
+	nextID     int
+	images     map[string]*Image
+	pulls      []Pull
+	builds     []Build
+	buildCache []build.CacheRecord
+}
+
+// New returns an empty fake client.
+func New() *Client {
+	return &Client{containers: map[string]*Container{}, images: map[string]*Image{}}
+}
+
+// Calls returns the names of the methods called so far, in order, each
+// followed by its main argument, e.g. "ContainerStart abc123".
+func (c *Client) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+// Containers returns the containers that currently exist, sorted by ID.
+func (c *Client) Containers() []Container {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]Container, 0, len(c.containers))
+	for _, ctr := range c.containers {
+		list = append(list, *ctr)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// AddContainer registers an existing container, as if created outside the
+// code under test.
+func (c *Client) AddContainer(ctr Container) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ctr.Config == nil {
+		ctr.Config = &container.Config{}
+	}
+	c.containers[ctr.ID] = &ctr
+}
+
+// SetExited marks a container as stopped with the given exit code.
+func (c *Client) SetExited(id string, exitCode int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ctr, ok := c.containers[id]; ok {
+		ctr.Running = false
+		ctr.ExitCode = exitCode
+	}
+}
+
+func (c *Client) record(call, arg string) {
+	c.calls = append(c.calls, strings.TrimSpace(call+" "+arg))
+}
+
+// lookup finds a container by ID, ID prefix or name. c.mu must be held.
+func (c *Client) lookup(ref string) (*Container, error) {
+	ref = strings.TrimPrefix(ref, "/")
+	if ref != "" {
+		for _, ctr := range c.containers {
+			if ctr.ID == ref || ctr.Name == ref || strings.HasPrefix(ctr.ID, ref) {
+				return ctr, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("No such container: %s: %w", ref, errdefs.ErrNotFound)
+}
+
+func (c *Client) ContainerCreate(ctx context.Context, options client.ContainerCreateOptions) (client.ContainerCreateResult, error) {
+	if c.OnCreate != nil {
+		c.OnCreate(options.Name)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ContainerCreate", options.Name)
+	if err := ctx.Err(); err != nil {
+		return client.ContainerCreateResult{}, err
+	}
+	if options.Name != "" {
+		if _, err := c.lookup(options.Name); err == nil {
+			return client.ContainerCreateResult{}, fmt.Errorf("container name %q is already in use: %w", options.Name, errdefs.ErrConflict)
+		}
+	}
+	c.nextID++
+	id := fmt.Sprintf("%064x", c.nextID)
+	c.containers[id] = &Container{
+		ID:         id,
+		Name:       options.Name,
+		Config:     options.Config,
+		HostConfig: options.HostConfig,
+	}
+	return client.ContainerCreateResult{ID: id}, nil
+}
+
+func (c *Client) ContainerStart(ctx context.Context, id string, options client.ContainerStartOptions) (client.ContainerStartResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ContainerStart", id)
+	ctr, err := c.lookup(id)
+	if err != nil {
+		return client.ContainerStartResult{}, err
+	}
+	ctr.Running = true
+	return client.ContainerStartResult{}, nil
+}
+
+func (c *Client) ContainerStop(ctx context.Context, id string, options client.ContainerStopOptions) (client.ContainerStopResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ContainerStop", id)
+	ctr, err := c.lookup(id)
+	if err != nil {
+		return client.ContainerStopResult{}, err
+	}
+	ctr.Running = false
+	return client.ContainerStopResult{}, nil
+}
+
+func (c *Client) ContainerRemove(ctx context.Context, id string, options client.ContainerRemoveOptions) (client.ContainerRemoveResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ContainerRemove", id)
+	ctr, err := c.lookup(id)
+	if err != nil {
+		return client.ContainerRemoveResult{}, err
+	}
+	if ctr.Running && !options.Force {
+		return client.ContainerRemoveResult{}, fmt.Errorf("container %s is running: %w", ctr.ID, errdefs.ErrConflict)
+	}
+	delete(c.containers, ctr.ID)
+	return client.ContainerRemoveResult{}, nil
+}
+
+func (c *Client) ContainerInspect(ctx context.Context, id string, options client.ContainerInspectOptions) (client.ContainerInspectResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ContainerInspect", id)
+	ctr, err := c.lookup(id)
+	if err != nil {
+		return client.ContainerInspectResult{}, err
+	}
+	status := container.StateExited
+	if ctr.Running {
+		status = container.StateRunning
+	}
+	return client.ContainerInspectResult{Container: container.InspectResponse{
+		ID:   ctr.ID,
+		Name: "/" + ctr.Name,
+		State: &container.State{
+			Status:   status,
+			Running:  ctr.Running,
+			ExitCode: ctr.ExitCode,
+		},
+		Config:     ctr.Config,
+		HostConfig: ctr.HostConfig,
+	}}, nil
+}
+
+// ContainerList supports the "label" filter with "key" or "key=value" terms.
+func (c *Client) ContainerList(ctx context.Context, options client.ContainerListOptions) (client.ContainerListResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ContainerList", "")
+	var items []container.Summary
+	for _, ctr := range c.containers {
+		if !ctr.Running && !options.All {
+			continue
+		}
+		if !matchLabels(ctr.Config.Labels, options.Filters["label"]) {
+			continue
+		}
+		state := container.StateExited
+		if ctr.Running {
+			state = container.StateRunning
+		}
+		items = append(items, container.Summary{
+			ID:     ctr.ID,
+			Names:  []string{"/" + ctr.Name},
+			Image:  ctr.Config.Image,
+			Labels: ctr.Config.Labels,
+			State:  state,
+			Ports:  portSummaries(ctr),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return client.ContainerListResult{Items: items}, nil
+}
+
+// portSummaries reports a running container's published ports.
+func portSummaries(ctr *Container) []container.PortSummary {
+	if !ctr.Running || ctr.HostConfig == nil {
+		return nil
+	}
+	var ports []container.PortSummary
+	for port, bindings := range ctr.HostConfig.PortBindings {
+		for _, b := range bindings {
+			public, _ := strconv.ParseUint(b.HostPort, 10, 16)
+			ports = append(ports, container.PortSummary{
+				IP:          b.HostIP,
+				PrivatePort: port.Num(),
+				PublicPort:  uint16(public),
+				Type:        string(port.Proto()),
+			})
+		}
+	}
+	return ports
+}
+
+func matchLabels(labels map[string]string, terms map[string]bool) bool {
+	for term := range terms {
+		key, value, hasValue := strings.Cut(term, "=")
+		got, ok := labels[key]
+		if !ok || (hasValue && got != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainerAttach returns a connection whose output is empty.
+func (c *Client) ContainerAttach(ctx context.Context, id string, options client.ContainerAttachOptions) (client.ContainerAttachResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ContainerAttach", id)
+	if _, err := c.lookup(id); err != nil {
+		return client.ContainerAttachResult{}, err
+	}
+	return client.ContainerAttachResult{HijackedResponse: hijacked()}, nil
+}
+
+func (c *Client) ContainerLogs(ctx context.Context, id string, options client.ContainerLogsOptions) (client.ContainerLogsResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ContainerLogs", id)
+	ctr, err := c.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(ctr.Logs)), nil
+}
+
+func (c *Client) ExecCreate(ctx context.Context, id string, options client.ExecCreateOptions) (client.ExecCreateResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ExecCreate", id+" "+strings.Join(options.Cmd, " "))
+	if _, err := c.lookup(id); err != nil {
+		return client.ExecCreateResult{}, err
+	}
+	return client.ExecCreateResult{ID: "exec-" + id}, nil
+}
+
+func (c *Client) ExecAttach(ctx context.Context, execID string, options client.ExecAttachOptions) (client.ExecAttachResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ExecAttach", execID)
+	return client.ExecAttachResult{HijackedResponse: hijacked()}, nil
+}
+
+func (c *Client) ExecInspect(ctx context.Context, execID string, options client.ExecInspectOptions) (client.ExecInspectResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ExecInspect", execID)
+	return client.ExecInspectResult{ID: execID, ExitCode: c.ExecExitCode}, nil
+}
+
+// hijacked returns a connection that reads EOF and discards writes.
+func hijacked() client.HijackedResponse {
+	local, remote := net.Pipe()
+	remote.Close()
+	return client.HijackedResponse{Conn: local, Reader: bufio.NewReader(local)}
+}
@@ -0,0 +1,321 @@
+package dockerfake
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"sort"
+	"strings"
+
+	"github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/build"
+	"github.com/moby/moby/api/types/image"
+	"github.com/moby/moby/api/types/jsonstream"
+	"github.com/moby/moby/client"
+)
+
+// Image is an image held by the fake daemon, stored under one reference.
+type Image struct {
+	Ref         string
+	ID          string
+	RepoDigests []string
+	Labels      map[string]string
+	Size        int64
+}
+
+// Pull records one ImagePull call.
+type Pull struct {
+	Ref     string
+	Options client.ImagePullOptions
+}
+
+// Build records one ImageBuild call.
+type Build struct {
+	Options client.ImageBuildOptions
+	// Files lists the names of the entries in the build context.
+	Files []string
+}
+
+// AddImage registers an image under img.Ref.
+func (c *Client) AddImage(img Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addImage(img)
+}
+
+func (c *Client) addImage(img Image) {
+	if c.images == nil {
+		c.images = map[string]*Image{}
+	}
+	c.images[normalizeRef(img.Ref)] = &img
+}
+
+// Images returns the images that currently exist, sorted by reference.
+func (c *Client) Images() []Image {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]Image, 0, len(c.images))
+	for _, img := range c.images {
+		list = append(list, *img)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Ref < list[j].Ref })
+	return list
+}
+
+// Pulls returns the ImagePull calls made so far.
+func (c *Client) Pulls() []Pull {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Pull(nil), c.pulls...)
+}
+
+// Builds returns the ImageBuild calls made so far.
+func (c *Client) Builds() []Build {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Build(nil), c.builds...)
+}
+
+// SetBuildCache replaces the build cache records reported by DiskUsage.
+func (c *Client) SetBuildCache(records []build.CacheRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buildCache = append([]build.CacheRecord(nil), records...)
+}
+
+// normalizeRef adds the implicit :latest tag.
+func normalizeRef(ref string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	if i := strings.LastIndex(ref, ":"); i <= strings.LastIndex(ref, "/") {
+		return ref + ":latest"
+	}
+	return ref
+}
+
+func (c *Client) lookupImage(ref string) (*Image, error) {
+	if img, ok := c.images[normalizeRef(ref)]; ok {
+		return img, nil
+	}
+	for _, img := range c.images {
+		if img.ID == ref {
+			return img, nil
+		}
+	}
+	return nil, fmt.Errorf("No such image: %s: %w", ref, errdefs.ErrNotFound)
+}
+
+// ImagePull adds ref to the fake's images unless PullErrors has an entry for
+// it. The returned stream carries PullOutput, or a single status message.
+func (c *Client) ImagePull(ctx context.Context, ref string, options client.ImagePullOptions) (client.ImagePullResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ImagePull", ref)
+	c.pulls = append(c.pulls, Pull{Ref: ref, Options: options})
+	if err := c.PullErrors[ref]; err != nil {
+		return nil, err
+	}
+	if _, err := c.lookupImage(ref); err != nil {
+		c.addImage(Image{Ref: ref, ID: fmt.Sprintf("sha256:%064x", len(c.images)+1)})
+	}
+	output := c.PullOutput
+	if output == "" {
+		output = fmt.Sprintf(`{"status":"Status: Downloaded newer image for %s"}`+"\n", ref)
+	}
+	return &pullResponse{ReadCloser: io.NopCloser(strings.NewReader(output))}, nil
+}
+
+func (c *Client) ImageTag(ctx context.Context, options client.ImageTagOptions) (client.ImageTagResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ImageTag", options.Source+" "+options.Target)
+	img, err := c.lookupImage(options.Source)
+	if err != nil {
+		return client.ImageTagResult{}, err
+	}
+	tagged := *img
+	tagged.Ref = options.Target
+	c.addImage(tagged)
+	return client.ImageTagResult{}, nil
+}
+
+func (c *Client) ImageInspect(ctx context.Context, ref string, _ ...client.ImageInspectOption) (client.ImageInspectResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ImageInspect", ref)
+	img, err := c.lookupImage(ref)
+	if err != nil {
+		return client.ImageInspectResult{}, err
+	}
+	return client.ImageInspectResult{InspectResponse: image.InspectResponse{
+		ID:          img.ID,
+		RepoTags:    []string{img.Ref},
+		RepoDigests: img.RepoDigests,
+		Size:        img.Size,
+	}}, nil
+}
+
+func (c *Client) ImageList(ctx context.Context, options client.ImageListOptions) (client.ImageListResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ImageList", "")
+	var items []image.Summary
+	for _, img := range c.images {
+		items = append(items, image.Summary{
+			ID:          img.ID,
+			RepoTags:    []string{img.Ref},
+			RepoDigests: img.RepoDigests,
+			Labels:      img.Labels,
+			Size:        img.Size,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].RepoTags[0] < items[j].RepoTags[0] })
+	return client.ImageListResult{Items: items}, nil
+}
+
+func (c *Client) ImageRemove(ctx context.Context, ref string, options client.ImageRemoveOptions) (client.ImageRemoveResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ImageRemove", ref)
+	img, err := c.lookupImage(ref)
+	if err != nil {
+		return client.ImageRemoveResult{}, err
+	}
+	for key, other := range c.images {
+		if other.ID == img.ID {
+			delete(c.images, key)
+		}
+	}
+	return client.ImageRemoveResult{Items: []image.DeleteResponse{{Deleted: img.ID}}}, nil
+}
+
+// ImageBuild records the options and context file names, adds an image for
+// each tag and returns BuildOutput as the build stream.
+func (c *Client) ImageBuild(ctx context.Context, buildContext io.Reader, options client.ImageBuildOptions) (client.ImageBuildResult, error) {
+	var files []string
+	tr := tar.NewReader(buildContext)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return client.ImageBuildResult{}, fmt.Errorf("reading build context: %w", err)
+		}
+		files = append(files, hdr.Name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ImageBuild", strings.Join(options.Tags, ","))
+	c.builds = append(c.builds, Build{Options: options, Files: files})
+	if c.BuildErr != nil {
+		return client.ImageBuildResult{}, c.BuildErr
+	}
+	for _, tag := range options.Tags {
+		c.addImage(Image{Ref: tag, ID: fmt.Sprintf("sha256:%064x", len(c.images)+1), Labels: options.Labels})
+	}
+	return client.ImageBuildResult{Body: io.NopCloser(bytes.NewBufferString(c.BuildOutput))}, nil
+}
+
+// BuildCachePrune removes the records selected by an "id" filter, or all of
+// them without one.
+func (c *Client) BuildCachePrune(ctx context.Context, opts client.BuildCachePruneOptions) (client.BuildCachePruneResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := opts.Filters["id"]
+	c.record("BuildCachePrune", strings.Join(sortedKeys(ids), ","))
+	var report build.CachePruneReport
+	kept := c.buildCache[:0]
+	for _, rec := range c.buildCache {
+		if len(ids) == 0 || ids[rec.ID] {
+			report.CachesDeleted = append(report.CachesDeleted, rec.ID)
+			report.SpaceReclaimed += uint64(rec.Size)
+			continue
+		}
+		kept = append(kept, rec)
+	}
+	c.buildCache = kept
+	return client.BuildCachePruneResult{Report: report}, nil
+}
+
+func (c *Client) DiskUsage(ctx context.Context, options client.DiskUsageOptions) (client.DiskUsageResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("DiskUsage", "")
+	var result client.DiskUsageResult
+	result.BuildCache.Items = append([]build.CacheRecord(nil), c.buildCache...)
+	result.BuildCache.TotalCount = int64(len(c.buildCache))
+	return result, nil
+}
+
+// ServerVersion reports OS and Arch, defaulting to linux/amd64.
+func (c *Client) ServerVersion(ctx context.Context, options client.ServerVersionOptions) (client.ServerVersionResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("ServerVersion", "")
+	result := client.ServerVersionResult{Os: "linux", Arch: "amd64"}
+	if c.OS != "" {
+		result.Os = c.OS
+	}
+	if c.Arch != "" {
+		result.Arch = c.Arch
+	}
+	return result, nil
+}
+
+func (c *Client) Ping(ctx context.Context, options client.PingOptions) (client.PingResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.record("Ping", "")
+	return client.PingResult{}, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pullResponse implements client.ImagePullResponse over a JSON message stream.
+type pullResponse struct {
+	io.ReadCloser
+}
+
+func (r *pullResponse) JSONMessages(ctx context.Context) iter.Seq2[jsonstream.Message, error] {
+	return func(yield func(jsonstream.Message, error) bool) {
+		defer r.Close()
+		dec := json.NewDecoder(r)
+		for {
+			var msg jsonstream.Message
+			err := dec.Decode(&msg)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if !yield(msg, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (r *pullResponse) Wait(ctx context.Context) error {
+	for msg, err := range r.JSONMessages(ctx) {
+		if err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+	}
+	return nil
+}
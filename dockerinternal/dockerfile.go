@@ -0,0 +1,463 @@
+package dockerinternal
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DockerfileInstruction is one Dockerfile instruction with its line
+// continuations joined.
+type DockerfileInstruction struct {
+	// Line is the 1-based line the instruction starts on.
+	Line int
+	// Command is the upper-cased instruction, e.g. FROM or RUN.
+	Command string
+	// Args is the rest of the instruction, with continuations joined by a
+	// space.
+	Args string
+	// Heredocs are the here-documents of a RUN, COPY or ADD, e.g. the
+	// script of RUN <<EOF.
+	Heredocs []DockerfileHeredoc
+}
+
+// DockerfileHeredoc is a here-document following an instruction.
+type DockerfileHeredoc struct {
+	Delimiter string
+	// Line is the line of the first body line.
+	Line int
+	// Body is the content between the instruction and the delimiter,
+	// without the leading tabs <<- strips.
+	Body string
+}
+
+// DockerfileArg is an ARG declaration.
+type DockerfileArg struct {
+	Name    string
+	Default *string
+}
+
+// DockerfileStage is a build stage: a FROM instruction and what follows it.
+type DockerfileStage struct {
+	Index int
+	// Name is the stage's AS name, lower-cased as docker does, or "" for
+	// an unnamed stage.
+	Name string
+	// Base is the FROM image or stage as written, e.g.
+	// docker.io/hugomods/hugo:${HUGO_VERSION}.
+	Base         string
+	Line         int
+	Instructions []DockerfileInstruction
+}
+
+// ID returns the stage name, or its index for an unnamed stage, as
+// --target and COPY --from accept it.
+func (s DockerfileStage) ID() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return strconv.Itoa(s.Index)
+}
+
+// Dockerfile is a parsed Dockerfile.
+type Dockerfile struct {
+	// GlobalArgs are the ARGs before the first FROM, which FROM lines may
+	// reference.
+	GlobalArgs []DockerfileArg
+	Stages     []DockerfileStage
+	escape     rune
+}
+
+// LoadDockerfile reads and parses the Dockerfile at path.
+func LoadDockerfile(path string) (*Dockerfile, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Can't find %s...", path)
+	}
+	df, err := ParseDockerfile(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return df, nil
+}
+
+// ParseDockerfile splits content into instructions and build stages. It
+// honours the escape parser directive, comments, line continuations and
+// here-documents.
+func ParseDockerfile(content string) (*Dockerfile, error) {
+	df := &Dockerfile{escape: '\\'}
+	instructions, err := df.instructions(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, inst := range instructions {
+		if inst.Command == "FROM" {
+			stage, err := parseFrom(inst)
+			if err != nil {
+				return nil, err
+			}
+			stage.Index = len(df.Stages)
+			df.Stages = append(df.Stages, stage)
+			continue
+		}
+		if len(df.Stages) == 0 {
+			if inst.Command != "ARG" {
+				return nil, fmt.Errorf("line %d: %s before the first FROM", inst.Line, inst.Command)
+			}
+			df.GlobalArgs = append(df.GlobalArgs, parseArgs(inst.Args)...)
+			continue
+		}
+		stage := &df.Stages[len(df.Stages)-1]
+		stage.Instructions = append(stage.Instructions, inst)
+	}
+	if len(df.Stages) == 0 {
+		return nil, fmt.Errorf("no FROM instruction in Dockerfile")
+	}
+	return df, nil
+}
+
+// instructions reads the parser directives and returns the instructions of
+// content.
+func (df *Dockerfile) instructions(content string) ([]DockerfileInstruction, error) {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+
+	// Parser directives are "# key=value" comments at the very top.
+	for _, line := range lines {
+		key, value, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(line), "#"), "=")
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") || !ok {
+			break
+		}
+		if strings.EqualFold(strings.TrimSpace(key), "escape") {
+			switch value = strings.TrimSpace(value); value {
+			case "\\", "`":
+				df.escape = rune(value[0])
+			default:
+				return nil, fmt.Errorf("invalid escape directive %q", value)
+			}
+		}
+	}
+
+	var instructions []DockerfileInstruction
+	var current *DockerfileInstruction
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if current == nil {
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			command, args, _ := strings.Cut(trimmed, " ")
+			if tab := strings.IndexByte(command, '\t'); tab >= 0 {
+				command, args = command[:tab], command[tab+1:]+" "+args
+			}
+			current = &DockerfileInstruction{Line: i + 1, Command: strings.ToUpper(command)}
+			trimmed = strings.TrimSpace(args)
+		} else if strings.HasPrefix(trimmed, "#") {
+			// Comments inside a continued instruction are dropped.
+			continue
+		}
+
+		continued := strings.HasSuffix(trimmed, string(df.escape))
+		if continued {
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, string(df.escape)))
+		}
+		if trimmed != "" {
+			if current.Args != "" {
+				current.Args += " "
+			}
+			current.Args += trimmed
+		}
+		if !continued {
+			i = readHeredocs(current, lines, i+1) - 1
+			instructions = append(instructions, *current)
+			current = nil
+		}
+	}
+	if current != nil {
+		instructions = append(instructions, *current)
+	}
+	return instructions, nil
+}
+
+// heredocCommands are the instructions that accept here-documents.
+var heredocCommands = map[string]bool{"RUN": true, "COPY": true, "ADD": true}
+
+// heredocMarker matches <<EOF, <<-EOF, <<"EOF" and <<'EOF'.
+var heredocMarker = regexp.MustCompile(`<<(-?)(["']?)([A-Za-z_][A-Za-z0-9_]*)(["']?)`)
+
+// readHeredocs reads the bodies of the here-documents inst opens from
+// lines[start:] and returns the index of the first line after them.
+func readHeredocs(inst *DockerfileInstruction, lines []string, start int) int {
+	if !heredocCommands[inst.Command] {
+		return start
+	}
+	for _, m := range heredocMarker.FindAllStringSubmatchIndex(inst.Args, -1) {
+		// <<< is a here-string, not a here-document.
+		if m[0] > 0 && inst.Args[m[0]-1] == '<' {
+			continue
+		}
+		if inst.Args[m[4]:m[5]] != inst.Args[m[8]:m[9]] {
+			continue
+		}
+		stripTabs := m[3] > m[2]
+		heredoc := DockerfileHeredoc{Delimiter: inst.Args[m[6]:m[7]], Line: start + 1}
+		var body []string
+		for ; start < len(lines); start++ {
+			line := lines[start]
+			if stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == heredoc.Delimiter {
+				start++
+				break
+			}
+			body = append(body, line)
+		}
+		heredoc.Body = strings.Join(body, "\n")
+		inst.Heredocs = append(inst.Heredocs, heredoc)
+	}
+	return start
+}
+
+// parseFrom parses FROM [--platform=...] image [AS name].
+func parseFrom(inst DockerfileInstruction) (DockerfileStage, error) {
+	var fields []string
+	for _, f := range strings.Fields(inst.Args) {
+		if !strings.HasPrefix(f, "--") {
+			fields = append(fields, f)
+		}
+	}
+	stage := DockerfileStage{Line: inst.Line}
+	switch {
+	case len(fields) == 1:
+		stage.Base = fields[0]
+	case len(fields) == 3 && strings.EqualFold(fields[1], "AS"):
+		stage.Base, stage.Name = fields[0], strings.ToLower(fields[2])
+	default:
+		return DockerfileStage{}, fmt.Errorf("line %d: invalid FROM %q", inst.Line, inst.Args)
+	}
+	return stage, nil
+}
+
+// parseArgs parses ARG name[=default] [name[=default]...].
+func parseArgs(args string) []DockerfileArg {
+	var parsed []DockerfileArg
+	for _, word := range splitWords(args) {
+		name, value, ok := strings.Cut(word, "=")
+		arg := DockerfileArg{Name: name}
+		if ok {
+			arg.Default = &value
+		}
+		parsed = append(parsed, arg)
+	}
+	return parsed
+}
+
+// parseEnv parses ENV name=value [name=value...] and the legacy ENV name
+// value form.
+func parseEnv(args string) [][2]string {
+	words := splitWords(args)
+	if len(words) > 0 && !strings.Contains(words[0], "=") {
+		return [][2]string{{words[0], strings.Join(words[1:], " ")}}
+	}
+	var pairs [][2]string
+	for _, word := range words {
+		if name, value, ok := strings.Cut(word, "="); ok {
+			pairs = append(pairs, [2]string{name, value})
+		}
+	}
+	return pairs
+}
+
+// splitWords splits s at unquoted whitespace and removes single and double
+// quotes, as the shell does.
+func splitWords(s string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// Stage returns the stage called name. Names are case-insensitive.
+func (df *Dockerfile) Stage(name string) (*DockerfileStage, bool) {
+	if i := df.stageIndex(name); i >= 0 {
+		return &df.Stages[i], true
+	}
+	return nil, false
+}
+
+func (df *Dockerfile) stageIndex(name string) int {
+	for i, stage := range df.Stages {
+		if stage.Name != "" && stage.Name == strings.ToLower(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// StageChain returns the target stage, or the last stage if target is
+// empty, preceded by the stages it is built FROM, starting with the one
+// based on an image. Global ARGs in FROM lines are expanded with buildArgs.
+func (df *Dockerfile) StageChain(target string, buildArgs map[string]string) ([]DockerfileStage, error) {
+	index := len(df.Stages) - 1
+	if target != "" {
+		if index = df.stageIndex(target); index < 0 {
+			return nil, fmt.Errorf("stage %q not found in Dockerfile", target)
+		}
+	}
+	var chain []DockerfileStage
+	for index >= 0 {
+		chain = append([]DockerfileStage{df.Stages[index]}, chain...)
+		// A FROM can only name an earlier stage.
+		parent := df.stageIndex(df.ExpandGlobal(df.Stages[index].Base, buildArgs))
+		if parent >= index {
+			break
+		}
+		index = parent
+	}
+	return chain, nil
+}
+
+// HasGlobalArg reports whether name is declared before the first FROM.
+func (df *Dockerfile) HasGlobalArg(name string) bool {
+	for _, arg := range df.GlobalArgs {
+		if arg.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DeclaresArg reports whether any ARG in the Dockerfile declares name.
+func (df *Dockerfile) DeclaresArg(name string) bool {
+	if df.HasGlobalArg(name) {
+		return true
+	}
+	for _, stage := range df.Stages {
+		for _, inst := range stage.Instructions {
+			if inst.Command != "ARG" {
+				continue
+			}
+			for _, arg := range parseArgs(inst.Args) {
+				if arg.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ExpandGlobal substitutes global ARGs in s, as docker does for FROM lines.
+// Values in buildArgs override the ARG defaults; only declared ARGs are
+// expanded and undeclared references become empty.
+func (df *Dockerfile) ExpandGlobal(s string, buildArgs map[string]string) string {
+	values := df.globalValues(buildArgs)
+	return expandVars(s, df.escape, func(name string) (string, bool) {
+		v, ok := values[name]
+		return v, ok
+	})
+}
+
+func (df *Dockerfile) globalValues(buildArgs map[string]string) map[string]string {
+	values := map[string]string{}
+	for _, arg := range df.GlobalArgs {
+		if v, ok := buildArgs[arg.Name]; ok {
+			values[arg.Name] = v
+		} else if arg.Default != nil {
+			values[arg.Name] = expandVars(*arg.Default, df.escape, func(name string) (string, bool) {
+				v, ok := values[name]
+				return v, ok
+			})
+		}
+	}
+	return values
+}
+
+// expandVars expands $NAME, ${NAME}, ${NAME:-word} and ${NAME:+word}.
+func expandVars(s string, escape rune, lookup func(string) (string, bool)) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == escape && i+1 < len(runes) && runes[i+1] == '$' {
+			b.WriteRune('$')
+			i++
+			continue
+		}
+		if r != '$' || i+1 == len(runes) {
+			b.WriteRune(r)
+			continue
+		}
+		if runes[i+1] == '{' {
+			end := i + 2
+			for end < len(runes) && runes[end] != '}' {
+				end++
+			}
+			if end == len(runes) {
+				b.WriteString(string(runes[i:]))
+				break
+			}
+			b.WriteString(expandBraced(string(runes[i+2:end]), lookup))
+			i = end
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && (runes[end] == '_' || isAlnum(runes[end])) {
+			end++
+		}
+		if end == i+1 {
+			b.WriteRune(r)
+			continue
+		}
+		value, _ := lookup(string(runes[i+1 : end]))
+		b.WriteString(value)
+		i = end - 1
+	}
+	return b.String()
+}
+
+func expandBraced(expr string, lookup func(string) (string, bool)) string {
+	if name, word, ok := strings.Cut(expr, ":-"); ok {
+		if v, set := lookup(name); set && v != "" {
+			return v
+		}
+		return word
+	}
+	if name, word, ok := strings.Cut(expr, ":+"); ok {
+		if v, set := lookup(name); set && v != "" {
+			return word
+		}
+		return ""
+	}
+	v, _ := lookup(expr)
+	return v
+}
+
+func isAlnum(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
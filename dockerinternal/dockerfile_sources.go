@@ -0,0 +1,247 @@
+package dockerinternal
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+)
+
+// centralRepoName is the repository workshop images take their Hugo theme
+// and layouts from.
+const centralRepoName = "CentralRepo"
+
+// DockerfileSource is a git repository a stage fetches, with ADD of a git
+// URL or RUN git clone.
+type DockerfileSource struct {
+	// Stage is the ID of the stage with the instruction.
+	Stage       string
+	Line        int
+	Instruction string
+	// URL is the repository without the #ref fragment.
+	URL string
+	// Branch is the branch, tag or commit fetched, or "" for the default
+	// branch.
+	Branch string
+}
+
+// IsCentralRepo reports whether s fetches CentralRepo.
+func (s DockerfileSource) IsCentralRepo() bool {
+	name := strings.TrimSuffix(path.Base(strings.TrimSuffix(s.URL, "/")), ".git")
+	return strings.EqualFold(name, centralRepoName)
+}
+
+// Sources returns the git repositories fetched by the target stage and the
+// stages it is built FROM, in build order. ARG and ENV values are expanded
+// as docker does, with buildArgs overriding ARG defaults.
+func (df *Dockerfile) Sources(target string, buildArgs map[string]string) ([]DockerfileSource, error) {
+	chain, err := df.StageChain(target, buildArgs)
+	if err != nil {
+		return nil, err
+	}
+	globals := df.globalValues(buildArgs)
+
+	var sources []DockerfileSource
+	// ENV is inherited from the parent stage; ARG is not.
+	env := map[string]string{}
+	for _, stage := range chain {
+		args := map[string]string{}
+		lookup := func(name string) (string, bool) {
+			if v, ok := env[name]; ok {
+				return v, true
+			}
+			v, ok := args[name]
+			return v, ok
+		}
+		for _, inst := range stage.Instructions {
+			switch inst.Command {
+			case "ARG":
+				for _, arg := range parseArgs(inst.Args) {
+					if v, ok := buildArgs[arg.Name]; ok {
+						args[arg.Name] = v
+					} else if arg.Default != nil {
+						args[arg.Name] = expandVars(*arg.Default, df.escape, lookup)
+					} else if v, ok := globals[arg.Name]; ok {
+						args[arg.Name] = v
+					}
+				}
+			case "ENV":
+				for _, pair := range parseEnv(inst.Args) {
+					env[pair[0]] = expandVars(pair[1], df.escape, lookup)
+				}
+			case "ADD", "RUN":
+				words := instructionWords(inst.Args)
+				if inst.Command == "RUN" && strings.HasPrefix(inst.Args, "[") && len(words) == 3 && words[1] == "-c" {
+					// RUN ["sh", "-c", "script"]
+					words = splitWords(words[2])
+				}
+				for i, w := range words {
+					words[i] = expandVars(w, df.escape, lookup)
+				}
+				var found []DockerfileSource
+				if inst.Command == "ADD" {
+					found = addSources(words)
+				} else {
+					found = cloneSources(words)
+				}
+				for _, src := range found {
+					src.Stage, src.Line, src.Instruction = stage.ID(), inst.Line, inst.Command
+					sources = append(sources, src)
+				}
+				if inst.Command != "RUN" {
+					continue
+				}
+				// A RUN here-document is a script, one command per line.
+				for _, heredoc := range inst.Heredocs {
+					for j, line := range strings.Split(heredoc.Body, "\n") {
+						words := splitWords(line)
+						for k, w := range words {
+							words[k] = expandVars(w, df.escape, lookup)
+						}
+						for _, src := range cloneSources(words) {
+							src.Stage, src.Line, src.Instruction = stage.ID(), heredoc.Line+j, inst.Command
+							sources = append(sources, src)
+						}
+					}
+				}
+			}
+		}
+	}
+	return sources, nil
+}
+
+// CentralRepoBranch returns the branch of the last CentralRepo source, which
+// is the one the image ends up with, or "" if no source is CentralRepo.
+func CentralRepoBranch(sources []DockerfileSource) string {
+	for i := len(sources) - 1; i >= 0; i-- {
+		if sources[i].IsCentralRepo() {
+			return sources[i].Branch
+		}
+	}
+	return ""
+}
+
+// instructionWords splits the arguments of an instruction in shell or
+// exec (JSON array) form. Exec form words are not split further.
+func instructionWords(args string) []string {
+	if strings.HasPrefix(args, "[") {
+		var words []string
+		if err := json.Unmarshal([]byte(args), &words); err == nil {
+			return words
+		}
+	}
+	return splitWords(args)
+}
+
+// addSources returns the git URLs among the sources of ADD [--flags] src...
+// dest, e.g. https://github.com/FortinetCloudCSE/CentralRepo.git#main.
+func addSources(words []string) []DockerfileSource {
+	for len(words) > 0 && strings.HasPrefix(words[0], "--") {
+		words = words[1:]
+	}
+	if len(words) < 2 {
+		return nil
+	}
+	var sources []DockerfileSource
+	for _, src := range words[:len(words)-1] {
+		url, fragment, hasFragment := strings.Cut(src, "#")
+		if !isGitURL(url) && !(hasFragment && isRemoteURL(url)) {
+			continue
+		}
+		// BuildKit fragments are ref:subdir.
+		branch, _, _ := strings.Cut(fragment, ":")
+		sources = append(sources, DockerfileSource{URL: url, Branch: branch})
+	}
+	return sources
+}
+
+func isRemoteURL(s string) bool {
+	for _, prefix := range []string{"https://", "http://", "git://", "ssh://", "git@"} {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isGitURL(s string) bool {
+	return isRemoteURL(s) && (strings.HasSuffix(s, ".git") || strings.HasPrefix(s, "git@") || strings.HasPrefix(s, "git://"))
+}
+
+// gitCloneValueOptions are the git clone options that take a separate
+// value word.
+var gitCloneValueOptions = map[string]bool{
+	"-o": true, "--origin": true, "-u": true, "--upload-pack": true,
+	"-c": true, "--config": true, "-j": true, "--jobs": true,
+	"--depth": true, "--reference": true, "--reference-if-able": true,
+	"--separate-git-dir": true, "--template": true, "--filter": true,
+	"--shallow-since": true, "--shallow-exclude": true, "--server-option": true,
+}
+
+// cloneSources finds git clone commands in the words of a RUN instruction,
+// including -b BRANCH, -bBRANCH, --branch BRANCH and --branch=BRANCH.
+func cloneSources(words []string) []DockerfileSource {
+	var sources []DockerfileSource
+	for _, command := range shellCommands(words) {
+		if len(command) < 2 || path.Base(command[0]) != "git" {
+			continue
+		}
+		// Skip git's own options, e.g. git -c advice.detachedHead=false clone.
+		args := command[1:]
+		for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+			if args[0] == "-c" || args[0] == "-C" {
+				args = args[1:]
+			}
+			args = args[1:]
+		}
+		if len(args) == 0 || args[0] != "clone" {
+			continue
+		}
+
+		var src DockerfileSource
+		for i := 1; i < len(args) && src.URL == ""; i++ {
+			arg := args[i]
+			switch {
+			case arg == "-b" || arg == "--branch":
+				if i+1 < len(args) {
+					i++
+					src.Branch = args[i]
+				}
+			case strings.HasPrefix(arg, "--branch="):
+				src.Branch = strings.TrimPrefix(arg, "--branch=")
+			case strings.HasPrefix(arg, "-b"):
+				src.Branch = strings.TrimPrefix(arg, "-b")
+			case gitCloneValueOptions[arg]:
+				i++
+			case strings.HasPrefix(arg, "-"):
+			default:
+				src.URL = arg
+			}
+		}
+		if src.URL != "" {
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+// shellCommands splits words at the shell operators &&, ||, ; and |.
+func shellCommands(words []string) [][]string {
+	var commands [][]string
+	var current []string
+	for _, w := range words {
+		switch w {
+		case "&&", "||", ";", "|":
+			commands = append(commands, current)
+			current = nil
+			continue
+		}
+		if trimmed, ok := strings.CutSuffix(w, ";"); ok {
+			current = append(current, trimmed)
+			commands = append(commands, current)
+			current = nil
+			continue
+		}
+		current = append(current, w)
+	}
+	return append(commands, current)
+}
@@ -0,0 +1,74 @@
+package dockerinternal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Environment is a --env choice of pull-image and build-image: the
+// Dockerfile stage it builds and the image it pulls or produces.
+type Environment struct {
+	Name        string // e.g. author-dev
+	Target      string // Dockerfile stage, e.g. prod
+	Image       string // repository, e.g. fortinet-hugo
+	Description string
+}
+
+// Environments lists the known environments. The first one is the default.
+// Adding an entry here is all a new environment needs.
+var Environments = []Environment{
+	{Name: "author-dev", Target: "prod", Image: "fortinet-hugo", Description: "production image for workshop authors"},
+	{Name: "admin-dev", Target: "dev", Image: "hugotester", Description: "dev/test image for CentralRepo changes"},
+}
+
+// DefaultEnvironment is the --env default.
+var DefaultEnvironment = Environments[0].Name
+
+// DefaultImage is the image launch-server runs by default: the one the
+// default environment pulls and builds.
+var DefaultImage = Environments[0].Image + ":latest"
+
+// RunnerImageNames are the repositories the runner pulls and builds.
+var RunnerImageNames = environmentImages()
+
+func environmentImages() []string {
+	names := make([]string, len(Environments))
+	for i, env := range Environments {
+		names[i] = env.Image
+	}
+	return names
+}
+
+// LookupEnvironment returns the environment called name.
+func LookupEnvironment(name string) (Environment, error) {
+	for _, env := range Environments {
+		if env.Name == name {
+			return env, nil
+		}
+	}
+	return Environment{}, fmt.Errorf("env must be one of %s, got %q", strings.Join(EnvironmentNames(), ", "), name)
+}
+
+// EnvironmentNames returns the names of Environments in order.
+func EnvironmentNames() []string {
+	names := make([]string, len(Environments))
+	for i, env := range Environments {
+		names[i] = env.Name
+	}
+	return names
+}
+
+// EnvironmentUsage describes the environments for --env help text, e.g.
+// "author-dev (prod stage) → fortinet-hugo image, production image for
+// workshop authors".
+func EnvironmentUsage() string {
+	var parts []string
+	for _, env := range Environments {
+		part := fmt.Sprintf("%s (%s stage) → %s image", env.Name, env.Target, env.Image)
+		if env.Description != "" {
+			part += ", " + env.Description
+		}
+		parts = append(parts, part)
+	}
+	return "Environment: " + strings.Join(parts, "; ") + "."
+}
@@ -0,0 +1,81 @@
+package dockerinternal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HugoVersionArg is the build arg build-image passes --hugo-version as.
+// A Dockerfile follows the flag with:
+//
+//	ARG HUGO_VERSION=std
+//	FROM docker.io/hugomods/hugo:${HUGO_VERSION} AS base
+const HugoVersionArg = "HUGO_VERSION"
+
+// hugoRepository is the Hugo base image.
+const hugoRepository = "docker.io/hugomods/hugo"
+
+// HugoBase is the hugomods/hugo image a build stage is based on.
+type HugoBase struct {
+	// Line is the line of the FROM instruction naming the image.
+	Line int
+	// From is the image as written, e.g. hugomods/hugo:${HUGO_VERSION}.
+	From string
+	// Image is the fully qualified image after ARG expansion.
+	Image string
+	// Tag is the Hugo version the image provides.
+	Tag string
+	// UsesArg reports whether the FROM references HUGO_VERSION.
+	UsesArg bool
+}
+
+// HugoBase follows the FROM lines of the target stage, or of the last stage
+// if target is empty, to the hugomods/hugo image it is built on. Global ARGs
+// are expanded with buildArgs as docker does. ok is false if the stage is
+// not built on hugomods/hugo.
+func (df *Dockerfile) HugoBase(target string, buildArgs map[string]string) (HugoBase, bool) {
+	chain, err := df.StageChain(target, buildArgs)
+	if err != nil {
+		return HugoBase{}, false
+	}
+	root := chain[0]
+	ref, err := ParseImageRef(df.ExpandGlobal(root.Base, buildArgs))
+	if err != nil || ref.Name() != hugoRepository {
+		return HugoBase{}, false
+	}
+	return HugoBase{
+		Line:    root.Line,
+		From:    root.Base,
+		Image:   ref.String(),
+		Tag:     ref.Tag,
+		UsesArg: usesHugoVersionArg(root.Base),
+	}, true
+}
+
+func usesHugoVersionArg(s string) bool {
+	return strings.Contains(s, "$"+HugoVersionArg) || strings.Contains(s, "${"+HugoVersionArg)
+}
+
+// HugoVersionWarnings explains why the image built for target will not use
+// Hugo version, e.g. because the Dockerfile pins another hugomods/hugo tag.
+func (df *Dockerfile) HugoVersionWarnings(target string, version string) []string {
+	if !df.HasGlobalArg(HugoVersionArg) {
+		// Docker expands an undeclared ARG in FROM to nothing.
+		for _, stage := range df.Stages {
+			if usesHugoVersionArg(stage.Base) {
+				return []string{fmt.Sprintf("Dockerfile line %d uses %s but does not declare ARG %s before the first FROM", stage.Line, HugoVersionArg, HugoVersionArg)}
+			}
+		}
+	}
+	base, ok := df.HugoBase(target, map[string]string{HugoVersionArg: version})
+	switch {
+	case !ok:
+		if df.DeclaresArg(HugoVersionArg) {
+			return nil
+		}
+		return []string{fmt.Sprintf("the Dockerfile neither builds on hugomods/hugo nor declares ARG %s; --hugo-version %s has no effect", HugoVersionArg, version)}
+	case !base.UsesArg && base.Tag != version:
+		return []string{fmt.Sprintf("Dockerfile line %d pins %s but --hugo-version is %s; the image is built with Hugo %s. Use FROM hugomods/hugo:${%s} to follow --hugo-version", base.Line, base.From, version, base.Tag, HugoVersionArg)}
+	}
+	return nil
+}
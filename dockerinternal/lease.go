@@ -0,0 +1,120 @@
+package dockerinternal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LeaseTTL is how long a session lease stays valid without being refreshed.
+// A runner refreshes its leases every LeaseTTL/3, so a lease older than this
+// belongs to a runner that was killed, even if its PID has been reused since.
+const LeaseTTL = 30 * time.Second
+
+const leaseDir = "leases"
+
+// leaseOwner is the content of a lease file.
+type leaseOwner struct {
+	Host string `json:"host"`
+	PID  int    `json:"pid"`
+}
+
+// LeasePath returns the file recording which runner serves containerID. The
+// file's modification time is its last refresh.
+func LeasePath(containerID string) (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, leaseDir, containerID), nil
+}
+
+// lease marks a container as served by this process until it is released.
+type lease struct {
+	path string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// acquireLease records this process as the owner of containerID and keeps
+// the record fresh in the background. It returns nil if the lease cannot be
+// written; ownership then falls back to the container's labels.
+func acquireLease(containerID string) *lease {
+	path, err := LeasePath(containerID)
+	if err != nil {
+		return nil
+	}
+	host, _ := os.Hostname()
+	data, err := json.Marshal(leaseOwner{Host: host, PID: os.Getpid()})
+	if err != nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return nil
+	}
+
+	l := &lease{path: path, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(LeaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				if err := os.Chtimes(path, now, now); errors.Is(err, os.ErrNotExist) {
+					// Write the lease again if it was deleted meanwhile.
+					os.WriteFile(path, data, 0o644)
+				}
+			}
+		}
+	}()
+	return l
+}
+
+// release stops refreshing the lease and removes it. A nil lease is a no-op.
+func (l *lease) release() {
+	if l == nil {
+		return
+	}
+	close(l.stop)
+	<-l.done
+	os.Remove(l.path)
+}
+
+// removeLease deletes containerID's lease once the container is gone.
+func removeLease(containerID string) {
+	if path, err := LeasePath(containerID); err == nil {
+		os.Remove(path)
+	}
+}
+
+// readLease returns the owner recorded for containerID and when the lease
+// was last refreshed.
+func readLease(containerID string) (leaseOwner, time.Time, error) {
+	var owner leaseOwner
+	path, err := LeasePath(containerID)
+	if err != nil {
+		return owner, time.Time{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return owner, time.Time{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return owner, time.Time{}, err
+	}
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return owner, time.Time{}, fmt.Errorf("invalid lease %s: %w", path, err)
+	}
+	return owner, info.ModTime(), nil
+}
@@ -0,0 +1,153 @@
+package dockerinternal
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Manifest list / index media types. A tag pointing at one of these is a
+// multi-arch image; docker pull records the index digest in RepoDigests.
+const (
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// manifestMediaTypes are accepted when resolving a tag, so the registry
+// returns the index for multi-arch images instead of converting it.
+var manifestMediaTypes = []string{
+	ocispec.MediaTypeImageIndex,
+	mediaTypeDockerManifestList,
+	ocispec.MediaTypeImageManifest,
+	mediaTypeDockerManifest,
+}
+
+// Platform identifies an OS and CPU architecture, e.g. linux/arm64/v8.
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// defaultVariants are assumed when a platform does not name a variant, as
+// containerd does.
+var defaultVariants = map[string]string{
+	"arm64": "v8",
+	"arm":   "v7",
+}
+
+func (p Platform) normalized() Platform {
+	if p.Variant == "" {
+		p.Variant = defaultVariants[p.Architecture]
+	}
+	return p
+}
+
+// PlatformManifest is one entry of a manifest list or OCI index.
+type PlatformManifest struct {
+	Digest   string
+	Platform Platform
+}
+
+// RemoteManifest is what a reference resolves to in a registry.
+type RemoteManifest struct {
+	// Digest is the digest of the top-level manifest: the index for
+	// multi-arch images. This is the digest docker pull records.
+	Digest    string
+	MediaType string
+	// Manifests lists the per-platform manifests of an index or manifest
+	// list. It is empty for single-platform images.
+	Manifests []PlatformManifest
+}
+
+// IsIndex reports whether the manifest is a manifest list or OCI index.
+func (m *RemoteManifest) IsIndex() bool {
+	return m.MediaType == ocispec.MediaTypeImageIndex || m.MediaType == mediaTypeDockerManifestList
+}
+
+// ForPlatform returns the index entry for p. An exact variant match is
+// preferred; without one, the architecture's default variant is used.
+func (m *RemoteManifest) ForPlatform(p Platform) (PlatformManifest, bool) {
+	want := p.normalized()
+	for _, exact := range []bool{true, false} {
+		for _, entry := range m.Manifests {
+			got := entry.Platform.normalized()
+			if got.OS != want.OS || got.Architecture != want.Architecture {
+				continue
+			}
+			if !exact || got.Variant == want.Variant {
+				return entry, true
+			}
+		}
+	}
+	return PlatformManifest{}, false
+}
+
+// Platforms lists the platforms an index provides.
+func (m *RemoteManifest) Platforms() []string {
+	var list []string
+	for _, entry := range m.Manifests {
+		list = append(list, entry.Platform.String())
+	}
+	return list
+}
+
+// Matches reports whether a local RepoDigest refers to this manifest. The
+// local digest is normally the index digest, but an image pulled by its
+// platform-specific digest records that one instead, so both levels count.
+func (m *RemoteManifest) Matches(localDigest string, p Platform) bool {
+	if localDigest == "" {
+		return false
+	}
+	if localDigest == m.Digest {
+		return true
+	}
+	entry, ok := m.ForPlatform(p)
+	return ok && entry.Digest == localDigest
+}
+
+// parseManifest fills a RemoteManifest from a registry response body.
+// contentType comes from the response header; the body's own mediaType
+// field is used when the header is missing or generic.
+func parseManifest(digest, contentType string, body []byte) (*RemoteManifest, error) {
+	var index ocispec.Index
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	if !slices.Contains(manifestMediaTypes, mediaType) {
+		mediaType = index.MediaType
+	}
+
+	m := &RemoteManifest{Digest: digest, MediaType: mediaType}
+	if !m.IsIndex() {
+		return m, nil
+	}
+	for _, desc := range index.Manifests {
+		// Skip attestation manifests, which buildx lists as unknown/unknown.
+		if desc.Platform == nil || desc.Platform.OS == "unknown" {
+			continue
+		}
+		m.Manifests = append(m.Manifests, PlatformManifest{
+			Digest: desc.Digest.String(),
+			Platform: Platform{
+				OS:           desc.Platform.OS,
+				Architecture: desc.Platform.Architecture,
+				Variant:      desc.Platform.Variant,
+			},
+		})
+	}
+	return m, nil
+}
@@ -0,0 +1,146 @@
+package dockerinternal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/moby/moby/client"
+)
+
+// AutoPort asks ResolveHostPort to pick the first free port starting at the
+// container port.
+const AutoPort = "auto"
+
+// portSearchRange is how many consecutive ports are tried when looking for a
+// free one.
+const portSearchRange = 100
+
+// PortInUseError reports a host port that is already taken.
+type PortInUseError struct {
+	Port   string
+	Holder string
+}
+
+func (e *PortInUseError) Error() string {
+	return fmt.Sprintf("host port %s is already in use by %s (use '--host-port auto' or '--port-fallback' to pick a free port)", e.Port, e.Holder)
+}
+
+// ResolveHostPort makes sure cfg.HostPort can be published. "auto" is
+// replaced by the first free port at or above the container port. A taken
+// port is replaced by the next free one when fallback is set; otherwise a
+// *PortInUseError naming the holder is returned.
+func ResolveHostPort(ctx context.Context, cli client.ContainerAPIClient, cfg *ServerConfig, fallback bool) error {
+	if strings.EqualFold(cfg.HostPort, AutoPort) {
+		start, err := strconv.Atoi(cfg.ContainerPort)
+		if err != nil {
+			return fmt.Errorf("invalid container port %q: %w", cfg.ContainerPort, err)
+		}
+		port, err := findFreePort(ctx, cli, cfg.BindAddress, start)
+		if err != nil {
+			return err
+		}
+		cfg.HostPort = port
+		return nil
+	}
+
+	port, err := strconv.Atoi(cfg.HostPort)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid host port %q", cfg.HostPort)
+	}
+	holder := portHolder(ctx, cli, cfg.BindAddress, port)
+	if holder == "" {
+		return nil
+	}
+	if !fallback {
+		return &PortInUseError{Port: cfg.HostPort, Holder: holder}
+	}
+	free, err := findFreePort(ctx, cli, cfg.BindAddress, port+1)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Host port %s is in use by %s; using %s instead.\n", cfg.HostPort, holder, free)
+	cfg.HostPort = free
+	return nil
+}
+
+func findFreePort(ctx context.Context, cli client.ContainerAPIClient, bind netip.Addr, start int) (string, error) {
+	for port := start; port < start+portSearchRange && port <= 65535; port++ {
+		if portHolder(ctx, cli, bind, port) == "" {
+			return strconv.Itoa(port), nil
+		}
+	}
+	return "", fmt.Errorf("no free host port found in range %d-%d", start, start+portSearchRange-1)
+}
+
+// portHolder returns a description of whatever holds port on the bind
+// address, or an empty string when it is free. Docker publishes ports through
+// iptables without a listening socket when the userland proxy is disabled, so
+// containers are checked before trying to bind.
+func portHolder(ctx context.Context, cli client.ContainerAPIClient, bind netip.Addr, port int) string {
+	if name := containerPublishing(ctx, cli, port); name != "" {
+		return "container " + name
+	}
+	host := ""
+	if bind.IsValid() && !bind.IsUnspecified() {
+		host = bind.String()
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err == nil {
+		ln.Close()
+		return ""
+	}
+	if proc := processListening(port); proc != "" {
+		return proc
+	}
+	return "another process"
+}
+
+func containerPublishing(ctx context.Context, cli client.ContainerAPIClient, port int) string {
+	list, err := cli.ContainerList(ctx, client.ContainerListOptions{})
+	if err != nil {
+		return ""
+	}
+	for _, c := range list.Items {
+		for _, p := range c.Ports {
+			if int(p.PublicPort) != port {
+				continue
+			}
+			name := ShortID(c.ID)
+			if len(c.Names) > 0 {
+				name = strings.TrimPrefix(c.Names[0], "/")
+			}
+			return fmt.Sprintf("%s (%s)", name, c.Image)
+		}
+	}
+	return ""
+}
+
+// processListening asks lsof which process listens on port. It is a
+// best-effort lookup and returns an empty string when lsof is unavailable.
+func processListening(port int) string {
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+	out, err := exec.Command("lsof", "-nP", "-iTCP:"+strconv.Itoa(port), "-sTCP:LISTEN", "-Fpc").Output()
+	if err != nil {
+		return ""
+	}
+	var pid, command string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "p") && pid == "" {
+			pid = line[1:]
+		} else if strings.HasPrefix(line, "c") && command == "" {
+			command = line[1:]
+		}
+	}
+	if pid == "" {
+		return ""
+	}
+	return fmt.Sprintf("process %s (pid %s)", command, pid)
+}
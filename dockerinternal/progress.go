@@ -0,0 +1,169 @@
+package dockerinternal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/moby/moby/api/types/jsonstream"
+)
+
+// progressBarWidth is the number of cells in a TTY progress bar.
+const progressBarWidth = 30
+
+// streamMessage is one message of a pull or build stream. Older daemons send
+// only the plain "error" field, newer ones errorDetail as well.
+type streamMessage struct {
+	jsonstream.Message
+	ErrorMessage string `json:"error,omitempty"`
+}
+
+// StreamError is an error reported inside a pull or build stream, e.g. a
+// failed RUN step.
+type StreamError struct {
+	Code    int
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	return e.Message
+}
+
+// DisplayJSONMessages renders the daemon's JSON message stream from r. On a
+// TTY each layer gets a progress bar that is updated in place; otherwise
+// output is line oriented and progress updates are skipped. The first error
+// message in the stream is returned as a *StreamError.
+func DisplayJSONMessages(r io.Reader, out io.Writer, tty bool) error {
+	p := &progressRenderer{out: out, tty: tty, lines: map[string]int{}}
+	dec := json.NewDecoder(r)
+	for {
+		var msg streamMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("error reading docker output: %w", err)
+		}
+		if msg.Error != nil {
+			return &StreamError{Code: msg.Error.Code, Message: msg.Error.Message}
+		}
+		if msg.ErrorMessage != "" {
+			return &StreamError{Message: msg.ErrorMessage}
+		}
+		p.render(msg.Message)
+	}
+}
+
+// displayStream renders a pull stream to stdout.
+func displayStream(r io.Reader) error {
+	return DisplayJSONMessages(r, os.Stdout, StdoutIsTerminal())
+}
+
+// StdoutIsTerminal reports whether stdout is a terminal, where progress can
+// be redrawn in place.
+func StdoutIsTerminal() bool {
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+type progressRenderer struct {
+	out io.Writer
+	tty bool
+	// lines maps a layer ID to the line it was first printed on.
+	lines map[string]int
+	// count is the number of lines printed so far.
+	count int
+	// partial is set while the last stream text did not end in a newline.
+	partial bool
+}
+
+func (p *progressRenderer) render(msg jsonstream.Message) {
+	switch {
+	case msg.Stream != "":
+		fmt.Fprint(p.out, msg.Stream)
+		p.count += strings.Count(msg.Stream, "\n")
+		p.partial = !strings.HasSuffix(msg.Stream, "\n")
+		return
+	case msg.Status == "":
+		// Aux messages (image IDs, BuildKit traces) carry nothing to show.
+		return
+	}
+	if p.partial {
+		fmt.Fprintln(p.out)
+		p.count++
+		p.partial = false
+	}
+
+	if !p.tty {
+		if msg.Progress != nil && msg.Progress.Total > 0 {
+			return
+		}
+		fmt.Fprintln(p.out, formatStatus(msg, false))
+		p.count++
+		return
+	}
+
+	line := formatStatus(msg, true)
+	if msg.ID != "" {
+		if idx, ok := p.lines[msg.ID]; ok {
+			// Move up to the layer's line, redraw it and move back down.
+			up := p.count - idx
+			fmt.Fprintf(p.out, "\x1b[%dA\r\x1b[2K%s\x1b[%dB\r", up, line, up)
+			return
+		}
+		p.lines[msg.ID] = p.count
+	}
+	fmt.Fprintf(p.out, "\x1b[2K%s\n", line)
+	p.count++
+}
+
+// formatStatus renders a status message, e.g.
+// "a1b2c3: Downloading [=====>      ]  12.0MiB/45.3MiB".
+func formatStatus(msg jsonstream.Message, bar bool) string {
+	line := msg.Status
+	if msg.ID != "" {
+		line = msg.ID + ": " + line
+	}
+	if bar && msg.Progress != nil && msg.Progress.Total > 0 {
+		line += " " + progressBar(msg.Progress)
+	}
+	return line
+}
+
+func progressBar(pr *jsonstream.Progress) string {
+	current := min(max(pr.Current, 0), pr.Total)
+	filled := int(current * progressBarWidth / pr.Total)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	s := "[" + bar + "]"
+	if !pr.HideCounts {
+		if pr.Units == "" {
+			s += fmt.Sprintf(" %8s/%s", FormatBytes(pr.Current), FormatBytes(pr.Total))
+		} else {
+			s += fmt.Sprintf(" %d/%d %s", pr.Current, pr.Total, pr.Units)
+		}
+	}
+	return s
+}
+
+// FormatBytes renders a size with binary units, e.g. 1.5GiB.
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,291 @@
+package dockerinternal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/moby/moby/api/types/build"
+	"github.com/moby/moby/api/types/image"
+	"github.com/moby/moby/client"
+)
+
+// buildCacheStateFile lists the build cache record IDs attributed to
+// build-image, so prune leaves the cache of other projects' builds alone.
+const buildCacheStateFile = "build-cache.json"
+
+// PrunePlan is what Prune would remove.
+type PrunePlan struct {
+	Containers []Session
+	Images     []image.Summary
+	BuildCache []build.CacheRecord
+}
+
+// PruneReport summarises a completed Prune.
+type PruneReport struct {
+	ContainersRemoved int
+	ImagesRemoved     int
+	CacheRemoved      int
+	SpaceReclaimed    uint64
+	Errors            []error
+}
+
+// TotalSize returns the disk space the plan would free.
+func (p *PrunePlan) TotalSize() int64 {
+	var total int64
+	for _, c := range p.Containers {
+		total += c.Size
+	}
+	for _, img := range p.Images {
+		total += img.Size
+	}
+	for _, rec := range p.BuildCache {
+		total += rec.Size
+	}
+	return total
+}
+
+// Empty reports whether there is nothing to prune.
+func (p *PrunePlan) Empty() bool {
+	return len(p.Containers) == 0 && len(p.Images) == 0 && len(p.BuildCache) == 0
+}
+
+// PlanPrune finds stopped runner containers, dangling runner images and the
+// build cache created by build-image.
+func PlanPrune(ctx context.Context, cli DockerClient) (*PrunePlan, error) {
+	plan := &PrunePlan{}
+
+	list, err := cli.ContainerList(ctx, client.ContainerListOptions{
+		All:     true,
+		Size:    true,
+		Filters: make(client.Filters).Add("label", LabelWorkshop),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list containers: %w", err)
+	}
+	for _, c := range list.Items {
+		if s := sessionFromSummary(c); !s.Running() {
+			plan.Containers = append(plan.Containers, s)
+		}
+	}
+
+	images, err := cli.ImageList(ctx, client.ImageListOptions{
+		Filters: make(client.Filters).Add("dangling", "true"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list images: %w", err)
+	}
+	for _, img := range images.Items {
+		if isRunnerImage(img) {
+			plan.Images = append(plan.Images, img)
+		}
+	}
+
+	plan.BuildCache, err = RunnerBuildCache(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// Prune removes everything in plan, continuing past individual failures.
+func Prune(ctx context.Context, cli DockerClient, plan *PrunePlan) PruneReport {
+	var report PruneReport
+	for _, c := range plan.Containers {
+		if _, err := cli.ContainerRemove(ctx, c.ContainerID, client.ContainerRemoveOptions{}); err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("container %s: %w", c.Name, err))
+			continue
+		}
+		removeLease(c.ContainerID)
+		report.ContainersRemoved++
+		report.SpaceReclaimed += uint64(c.Size)
+	}
+	for _, img := range plan.Images {
+		if _, err := cli.ImageRemove(ctx, img.ID, client.ImageRemoveOptions{PruneChildren: true}); err != nil {
+			report.Errors = append(report.Errors, fmt.Errorf("image %s: %w", ShortID(img.ID), err))
+			continue
+		}
+		report.ImagesRemoved++
+		report.SpaceReclaimed += uint64(img.Size)
+	}
+	if len(plan.BuildCache) > 0 {
+		removed, reclaimed, err := PruneRunnerBuildCache(ctx, cli, plan.BuildCache)
+		report.CacheRemoved = removed
+		report.SpaceReclaimed += reclaimed
+		if err != nil {
+			report.Errors = append(report.Errors, err)
+		}
+	}
+	return report
+}
+
+// isRunnerImage reports whether a dangling image was pulled or built by the
+// runner. Pulled images keep their repository digests after losing their tag;
+// built images carry the runner's version label.
+func isRunnerImage(img image.Summary) bool {
+	if _, ok := img.Labels[LabelVersion]; ok {
+		return true
+	}
+	for _, digest := range img.RepoDigests {
+		repo, _, _ := strings.Cut(digest, "@")
+		name := repo[strings.LastIndex(repo, "/")+1:]
+		for _, runnerName := range RunnerImageNames {
+			if name == runnerName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BuildCacheIDs returns the IDs of every build cache record on the daemon.
+func BuildCacheIDs(ctx context.Context, cli DockerClient) (map[string]bool, error) {
+	usage, err := cli.DiskUsage(ctx, client.DiskUsageOptions{BuildCache: true, Verbose: true})
+	if err != nil {
+		return nil, fmt.Errorf("could not read build cache usage: %w", err)
+	}
+	ids := make(map[string]bool, len(usage.BuildCache.Items))
+	for _, rec := range usage.BuildCache.Items {
+		ids[rec.ID] = true
+	}
+	return ids, nil
+}
+
+// RecordBuildCache remembers the cache records that appeared since before was
+// taken as belonging to the runner. The daemon does not say which build
+// created a record, so records from other builds that ran at the same time
+// are claimed too.
+func RecordBuildCache(ctx context.Context, cli DockerClient, before map[string]bool) error {
+	after, err := BuildCacheIDs(ctx, cli)
+	if err != nil {
+		return err
+	}
+	owned, err := readBuildCacheState()
+	if err != nil {
+		return err
+	}
+	for id := range after {
+		if !before[id] {
+			owned[id] = true
+		}
+	}
+	// Forget records the daemon no longer has.
+	for id := range owned {
+		if !after[id] {
+			delete(owned, id)
+		}
+	}
+	return writeBuildCacheState(owned)
+}
+
+// RunnerBuildCache returns the daemon's build cache records that were created
+// by build-image.
+func RunnerBuildCache(ctx context.Context, cli DockerClient) ([]build.CacheRecord, error) {
+	owned, err := readBuildCacheState()
+	if err != nil {
+		return nil, err
+	}
+	if len(owned) == 0 {
+		return nil, nil
+	}
+	usage, err := cli.DiskUsage(ctx, client.DiskUsageOptions{BuildCache: true, Verbose: true})
+	if err != nil {
+		return nil, fmt.Errorf("could not read build cache usage: %w", err)
+	}
+	var records []build.CacheRecord
+	for _, rec := range usage.BuildCache.Items {
+		if owned[rec.ID] && !rec.InUse {
+			records = append(records, rec)
+		}
+	}
+	return records, nil
+}
+
+// PruneRunnerBuildCache removes the given cache records by ID and forgets
+// them. It returns how many were removed and the space reclaimed.
+func PruneRunnerBuildCache(ctx context.Context, cli DockerClient, records []build.CacheRecord) (int, uint64, error) {
+	owned, err := readBuildCacheState()
+	if err != nil {
+		return 0, 0, err
+	}
+	var removed int
+	var reclaimed uint64
+	var errs []error
+	for _, rec := range records {
+		res, err := cli.BuildCachePrune(ctx, client.BuildCachePruneOptions{
+			All:     true,
+			Filters: make(client.Filters).Add("id", rec.ID),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("build cache %s: %w", rec.ID, err))
+			continue
+		}
+		removed += len(res.Report.CachesDeleted)
+		reclaimed += res.Report.SpaceReclaimed
+		for _, id := range res.Report.CachesDeleted {
+			delete(owned, id)
+		}
+		delete(owned, rec.ID)
+	}
+	if err := writeBuildCacheState(owned); err != nil {
+		errs = append(errs, err)
+	}
+	return removed, reclaimed, errors.Join(errs...)
+}
+
+// StateDir returns the directory the runner keeps its own state in.
+func StateDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "fortihugorunner"), nil
+}
+
+func readBuildCacheState() (map[string]bool, error) {
+	owned := map[string]bool{}
+	dir, err := StateDir()
+	if err != nil {
+		return owned, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, buildCacheStateFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return owned, nil
+		}
+		return nil, fmt.Errorf("failed to read build cache state: %w", err)
+	}
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("failed to parse build cache state: %w", err)
+	}
+	for _, id := range ids {
+		owned[id] = true
+	}
+	return owned, nil
+}
+
+func writeBuildCacheState(owned map[string]bool) error {
+	dir, err := StateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create state dir: %w", err)
+	}
+	ids := make([]string, 0, len(owned))
+	for id := range owned {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, buildCacheStateFile), data, 0o644)
+}
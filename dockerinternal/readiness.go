@@ -0,0 +1,116 @@
+package dockerinternal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moby/moby/client"
+)
+
+// ErrNotReady is returned by WaitForReady when the server did not answer
+// before the timeout while the container kept running.
+var ErrNotReady = errors.New("hugo server did not become ready in time")
+
+// ContainerExitedError is returned by WaitForReady when the container stops
+// before the server answers, typically because Hugo failed to build the site.
+type ContainerExitedError struct {
+	ContainerID string
+	ExitCode    int
+	Logs        string
+}
+
+func (e *ContainerExitedError) Error() string {
+	return fmt.Sprintf("container %s exited with code %d before the server was ready", ShortID(e.ContainerID), e.ExitCode)
+}
+
+const readinessPollInterval = 500 * time.Millisecond
+
+// WaitForReady polls url until it returns 200 OK, the container exits or the
+// timeout expires. When the container exits, the returned
+// *ContainerExitedError carries its last logLines lines of output.
+func WaitForReady(ctx context.Context, cli ContainerClient, containerID string, url string, timeout time.Duration, logLines int) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	httpClient := &http.Client{Timeout: 2 * time.Second}
+	ticker := time.NewTicker(readinessPollInterval)
+	defer ticker.Stop()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		if resp, err := httpClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		inspect, err := cli.ContainerInspect(ctx, containerID, client.ContainerInspectOptions{})
+		if err == nil && inspect.Container.State != nil && !inspect.Container.State.Running {
+			logs, _ := ContainerLogTail(context.Background(), cli, containerID, logLines)
+			return &ContainerExitedError{
+				ContainerID: containerID,
+				ExitCode:    inspect.Container.State.ExitCode,
+				Logs:        logs,
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return ErrNotReady
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ContainerLogTail returns the last n lines of a container's output. The
+// server containers run with a TTY, so the log stream is not multiplexed.
+func ContainerLogTail(ctx context.Context, cli ContainerClient, containerID string, n int) (string, error) {
+	out, err := cli.ContainerLogs(ctx, containerID, client.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       strconv.Itoa(n),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	data, err := io.ReadAll(out)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// ReportReadiness prints the outcome of WaitForReady in a consistent format.
+func ReportReadiness(err error, url string) {
+	var exited *ContainerExitedError
+	switch {
+	case errors.Is(err, ErrContainerReplaced):
+		// The restart that replaced the container reports on the new one.
+	case err == nil:
+		fmt.Printf("\n**** Hugo server ready at %s ****\n\n", url)
+	case errors.As(err, &exited):
+		fmt.Printf("\nError: %v\n", exited)
+		if exited.Logs != "" {
+			fmt.Println("Last container log lines:")
+			fmt.Println(exited.Logs)
+		}
+	case errors.Is(err, ErrNotReady):
+		fmt.Printf("\nWarning: %s is not answering yet; Hugo may still be building the site.\n", url)
+	default:
+		fmt.Printf("\nError waiting for Hugo server: %v\n", err)
+	}
+}
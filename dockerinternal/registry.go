@@ -0,0 +1,303 @@
+package dockerinternal
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/distribution/reference"
+)
+
+// DefaultRegistry is where the fortinet-hugo and hugotester images are
+// published. Images are referenced as DefaultRegistry + name.
+const DefaultRegistry = "public.ecr.aws/k4n6m5h8/"
+
+// dockerHubRegistry is the API host behind the docker.io domain.
+const dockerHubRegistry = "registry-1.docker.io"
+
+// maxManifestSize bounds how much of a manifest response is read.
+const maxManifestSize = 4 << 20
+
+// ImageRef is an image reference with Docker's defaults applied: a missing
+// registry means docker.io, official images get the library/ prefix and a
+// missing tag means latest.
+type ImageRef struct {
+	Domain string // e.g. docker.io, ghcr.io, localhost:5000
+	Path   string // e.g. library/alpine
+	Tag    string
+	Digest string
+}
+
+// ParseImageRef parses s following the distribution reference grammar.
+func ParseImageRef(s string) (ImageRef, error) {
+	named, err := reference.ParseNormalizedNamed(s)
+	if err != nil {
+		return ImageRef{}, fmt.Errorf("invalid image reference %q: %w", s, err)
+	}
+	named = reference.TagNameOnly(named)
+	ref := ImageRef{Domain: reference.Domain(named), Path: reference.Path(named)}
+	if tagged, ok := named.(reference.Tagged); ok {
+		ref.Tag = tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		ref.Digest = digested.Digest().String()
+	}
+	return ref, nil
+}
+
+// Name returns the fully qualified repository name, e.g.
+// docker.io/library/alpine.
+func (r ImageRef) Name() string {
+	return r.Domain + "/" + r.Path
+}
+
+// Reference returns the digest if there is one, otherwise the tag.
+func (r ImageRef) Reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// String returns the fully qualified reference.
+func (r ImageRef) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// registryHost returns the host serving the registry API for r.
+func (r ImageRef) registryHost() string {
+	if r.Domain == "docker.io" {
+		return dockerHubRegistry
+	}
+	return r.Domain
+}
+
+// RunnerImageRef returns the registry reference to check for a runner image
+// (fortinet-hugo or hugotester). Short names such as fortinet-hugo:latest are
+// looked up under registry; fully qualified names, e.g. a team's mirror, are
+// used as given. ok is false for other images.
+func RunnerImageRef(image, registry string) (remote string, ok bool) {
+	ref, err := ParseImageRef(image)
+	if err != nil {
+		return "", false
+	}
+	name := ref.Path[strings.LastIndex(ref.Path, "/")+1:]
+	if !slices.Contains(RunnerImageNames, name) {
+		return "", false
+	}
+	if ref.Domain == "docker.io" && ref.Path == "library/"+name {
+		return strings.TrimSuffix(registry, "/") + "/" + name + ":" + ref.Tag, true
+	}
+	return image, true
+}
+
+// Registry resolves manifest digests from OCI distribution registries.
+type Registry struct {
+	// Insecure lists registries (host or host:port) that may be reached
+	// over plain HTTP, or over HTTPS without certificate verification.
+	Insecure []string
+	// Credentials returns the username and password for a registry host.
+	// Empty values mean anonymous access.
+	Credentials func(host string) (username, password string, err error)
+	// HTTPClient is used for secure registries. It defaults to a client
+	// with a 30 second timeout.
+	HTTPClient *http.Client
+	// Cache, if set, is consulted by LocalImageCheck before asking the
+	// registry.
+	Cache *DigestCache
+}
+
+// NewRegistry returns a Registry that treats the given registries as
+// insecure and takes credentials from the docker config, as docker pull does.
+func NewRegistry(insecure []string) *Registry {
+	return &Registry{Insecure: insecure, Credentials: dockerCredentials}
+}
+
+func (r *Registry) isInsecure(host string) bool {
+	return slices.Contains(r.Insecure, host)
+}
+
+func (r *Registry) httpClient(insecure bool) *http.Client {
+	if r.HTTPClient != nil && !insecure {
+		return r.HTTPClient
+	}
+	c := &http.Client{Timeout: 30 * time.Second}
+	if insecure {
+		c.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+	}
+	return c
+}
+
+// ManifestDigest returns the digest of the manifest ref resolves to. A
+// reference that already has a digest is returned without contacting the
+// registry.
+func (r *Registry) ManifestDigest(ctx context.Context, ref ImageRef) (string, error) {
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	m, err := r.Manifest(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return m.Digest, nil
+}
+
+// Manifest fetches the manifest ref resolves to, including the platform
+// entries of a manifest list or OCI index.
+func (r *Registry) Manifest(ctx context.Context, ref ImageRef) (*RemoteManifest, error) {
+	host := ref.registryHost()
+	insecure := r.isInsecure(ref.Domain) || r.isInsecure(host)
+	schemes := []string{"https"}
+	if insecure {
+		schemes = append(schemes, "http")
+	}
+
+	var lastErr error
+	for _, scheme := range schemes {
+		manifestURL := fmt.Sprintf("%s://%s/v2/%s/manifests/%s", scheme, host, ref.Path, ref.Reference())
+		m, err := r.fetchManifest(ctx, r.httpClient(insecure), manifestURL, ref)
+		if err == nil {
+			return m, nil
+		}
+		lastErr = err
+		// Only fall back to plain HTTP when HTTPS could not connect; if
+		// the registry answered, another scheme will not help.
+		var urlErr *url.Error
+		if !errors.As(err, &urlErr) {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+// IsRegistryUnreachable reports whether err means the registry could not be
+// reached at all, e.g. no network, a DNS failure, a refused connection or a
+// timeout, as opposed to the registry answering with an error.
+func IsRegistryUnreachable(err error) bool {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &dnsErr), errors.As(err, &opErr):
+		return true
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return true
+	case errors.As(err, &urlErr) && urlErr.Timeout():
+		return true
+	}
+	return false
+}
+
+// daemonNetworkErrors are the messages the Docker daemon reports when it
+// cannot reach a registry. Daemon errors arrive as text, not as net errors.
+var daemonNetworkErrors = []string{
+	"dial tcp", "no such host", "i/o timeout", "connection refused",
+	"connection reset by peer", "network is unreachable",
+	"TLS handshake timeout", "Client.Timeout exceeded",
+	"request canceled while waiting for connection",
+}
+
+// IsPullUnreachable reports whether a failed pull means the registry could
+// not be reached, by this process or by the Docker daemon pulling for it.
+// Other failures, e.g. unauthorized or manifest unknown, are real errors.
+func IsPullUnreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if IsRegistryUnreachable(err) {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range daemonNetworkErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RegistryError is an unexpected HTTP response from a registry.
+type RegistryError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *RegistryError) Error() string {
+	if e.StatusCode == http.StatusNotFound {
+		return fmt.Sprintf("manifest not found at %s", e.URL)
+	}
+	return fmt.Sprintf("registry request %s failed: %s", e.URL, e.Status)
+}
+
+func (r *Registry) fetchManifest(ctx context.Context, httpClient *http.Client, manifestURL string, ref ImageRef) (*RemoteManifest, error) {
+	resp, err := r.getManifest(ctx, httpClient, manifestURL, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		auth, err := r.authorize(ctx, httpClient, challenge, ref)
+		if err != nil {
+			return nil, err
+		}
+		resp, err = r.getManifest(ctx, httpClient, manifestURL, auth)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: %s rejected the credentials for %s", ErrRegistryAuth, ref.Domain, ref.Name())
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &RegistryError{URL: manifestURL, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		sum := sha256.Sum256(body)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return parseManifest(digest, resp.Header.Get("Content-Type"), body)
+}
+
+func (r *Registry) getManifest(ctx context.Context, httpClient *http.Client, manifestURL, auth string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	req.Header.Set("User-Agent", registryUserAgent)
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	return httpClient.Do(req)
+}
@@ -0,0 +1,209 @@
+package dockerinternal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/moby/moby/client"
+)
+
+// ErrSessionClosed is returned when a container operation is requested after
+// the session has started shutting down.
+var ErrSessionClosed = errors.New("server session is shutting down")
+
+// ErrContainerReplaced is returned by ServerSession.WaitForReady when a
+// restart replaced the container it was waiting for. The restart waits for
+// and reports the new container itself.
+var ErrContainerReplaced = errors.New("container was replaced by a restart")
+
+// ServerSession owns the container serving a workshop for the lifetime of
+// launch-server. Start, Restart, Rebuild and Shutdown are serialised, so a
+// shutdown that arrives in the middle of a restart waits for it and removes
+// the new container instead of leaking it.
+type ServerSession struct {
+	cli ContainerClient
+	cfg ServerConfig
+
+	// op is held for the whole of each container operation.
+	op sync.Mutex
+
+	// mu guards the fields below; it is never held across Docker calls, so
+	// Kill and ContainerID do not wait for a running operation.
+	mu          sync.Mutex
+	containerID string
+	// lease marks containerID as served by this process.
+	lease   *lease
+	closing bool
+	// restarts counts Restart calls, so a wait can tell that its container
+	// was stopped by a restart rather than by Hugo failing.
+	restarts int
+}
+
+// NewServerSession creates a session for cfg. No container is started until
+// Start or Adopt is called.
+func NewServerSession(cli ContainerClient, cfg ServerConfig) *ServerSession {
+	return &ServerSession{cli: cli, cfg: cfg}
+}
+
+// Config returns the session's server configuration.
+func (s *ServerSession) Config() ServerConfig {
+	return s.cfg
+}
+
+// ContainerID returns the ID of the session's current container, or "" if
+// there is none.
+func (s *ServerSession) ContainerID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.containerID
+}
+
+// setContainerID records id as the session's container and moves the
+// session's lease to it.
+func (s *ServerSession) setContainerID(id string) {
+	var l *lease
+	if id != "" {
+		l = acquireLease(id)
+	}
+	s.mu.Lock()
+	old := s.lease
+	s.containerID, s.lease = id, l
+	s.mu.Unlock()
+	old.release()
+}
+
+func (s *ServerSession) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// Start creates, starts and attaches to a new container.
+func (s *ServerSession) Start(ctx context.Context) error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	if s.isClosing() {
+		return ErrSessionClosed
+	}
+	return s.start(ctx)
+}
+
+// Adopt takes ownership of an existing, running container and attaches to it.
+// The session's lease marks this process as the container's runner from then
+// on, so other runners do not take it for an orphan.
+func (s *ServerSession) Adopt(ctx context.Context, containerID string) error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	if s.isClosing() {
+		return ErrSessionClosed
+	}
+	s.setContainerID(containerID)
+	return AttachContainer(ctx, s.cli, containerID)
+}
+
+// start runs with op held. Docker calls use a context that is not cancelled
+// with ctx: a create interrupted half way could leave a container the session
+// does not know about.
+func (s *ServerSession) start(ctx context.Context) error {
+	dctx := context.WithoutCancel(ctx)
+	id, err := StartContainer(dctx, s.cli, s.cfg)
+	if err != nil {
+		return err
+	}
+	s.setContainerID(id)
+	return AttachContainer(dctx, s.cli, id)
+}
+
+// Restart replaces the current container with a new one. If shutdown begins
+// while the old container is being removed, no new container is started.
+func (s *ServerSession) Restart(ctx context.Context) error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	if s.isClosing() {
+		return ErrSessionClosed
+	}
+	s.mu.Lock()
+	s.restarts++
+	s.mu.Unlock()
+	if id := s.ContainerID(); id != "" {
+		StopAndRemoveContainer(s.cli, id)
+		s.setContainerID("")
+	}
+	if s.isClosing() {
+		return ErrSessionClosed
+	}
+	return s.start(ctx)
+}
+
+// Rebuild runs a Hugo build inside the current container.
+func (s *ServerSession) Rebuild(ctx context.Context) error {
+	s.op.Lock()
+	defer s.op.Unlock()
+	if s.isClosing() {
+		return ErrSessionClosed
+	}
+	return RebuildInContainer(ctx, s.cli, s.ContainerID())
+}
+
+// WaitForReady waits for the current container's server to answer, as
+// configured by ReadyTimeout and LogLines. It does not block other operations;
+// if a restart stops the container meanwhile, it returns ErrContainerReplaced.
+func (s *ServerSession) WaitForReady(ctx context.Context) error {
+	s.mu.Lock()
+	id, restarts := s.containerID, s.restarts
+	s.mu.Unlock()
+
+	err := WaitForReady(ctx, s.cli, id, ServerURL(s.cfg), s.cfg.ReadyTimeout, s.cfg.LogLines)
+	var exited *ContainerExitedError
+	if errors.As(err, &exited) {
+		s.mu.Lock()
+		replaced := s.restarts != restarts
+		s.mu.Unlock()
+		if replaced {
+			return ErrContainerReplaced
+		}
+	}
+	return err
+}
+
+// Shutdown stops and removes the session's container, waiting for any
+// operation in progress to finish first. Later operations fail with
+// ErrSessionClosed. It is safe to call more than once.
+func (s *ServerSession) Shutdown() {
+	s.mu.Lock()
+	s.closing = true
+	s.mu.Unlock()
+
+	s.op.Lock()
+	defer s.op.Unlock()
+	if id := s.ContainerID(); id != "" {
+		StopAndRemoveContainer(s.cli, id)
+		s.setContainerID("")
+	}
+}
+
+// Kill force-removes the session's container without waiting for a running
+// operation or a graceful stop. The container is also removed by name, in
+// case an interrupted operation created one whose ID was not yet recorded.
+func (s *ServerSession) Kill(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	id, l := s.containerID, s.lease
+	s.lease = nil
+	s.mu.Unlock()
+	defer l.release()
+
+	var errs []error
+	for _, ref := range []string{id, ContainerName(s.cfg.WatchDir)} {
+		if ref == "" {
+			continue
+		}
+		_, err := s.cli.ContainerRemove(ctx, ref, client.ContainerRemoveOptions{Force: true})
+		if err != nil && ref == id {
+			errs = append(errs, fmt.Errorf("could not remove container %s: %w", ShortID(id), err))
+		}
+	}
+	return errors.Join(errs...)
+}
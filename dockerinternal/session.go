@@ -0,0 +1,327 @@
+package dockerinternal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"fortihugorunner/version"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+// Labels set on every container started by the runner.
+const (
+	LabelWorkshop = "fortihugorunner.workshop"
+	LabelVersion  = "fortihugorunner.version"
+	LabelPort     = "fortihugorunner.port"
+	LabelImage    = "fortihugorunner.image"
+	LabelHost     = "fortihugorunner.host"
+	LabelPID      = "fortihugorunner.pid"
+)
+
+// LabelHugoVersion records the Hugo version an image was built with.
+const LabelHugoVersion = "fortihugorunner.hugo-version"
+
+// containerNamePrefix starts every runner-managed container name.
+const containerNamePrefix = "fortihugorunner-"
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// Session is a runner-managed container.
+type Session struct {
+	ContainerID string
+	Name        string
+	Workshop    string
+	Image       string
+	ImageID     string
+	Version     string
+	HostPort    string
+	BindAddress netip.Addr
+	State       string
+	Status      string
+	Created     time.Time
+	Size        int64
+	OwnerHost   string
+	OwnerPID    int
+}
+
+// Running reports whether the session's container is running.
+func (s Session) Running() bool {
+	return s.State == string(container.StateRunning)
+}
+
+// Orphaned reports whether the session's container is still running although
+// the runner process serving it on this machine has gone, e.g. after SIGKILL
+// or closing the terminal on Windows. The runner serving a container, which
+// after a reattach is not the one that started it, holds a lease on it in
+// StateDir; a lease that is no longer refreshed means its runner is gone even
+// if the PID has been reused. Containers without a lease fall back to the
+// owner recorded in their labels.
+func (s Session) Orphaned() bool {
+	if !s.Running() {
+		return false
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return false
+	}
+	if s.ContainerID != "" {
+		if owner, refreshed, err := readLease(s.ContainerID); err == nil {
+			if owner.Host != host {
+				return false
+			}
+			return time.Since(refreshed) > LeaseTTL || !processAlive(owner.PID)
+		}
+	}
+	if s.OwnerPID == 0 || host != s.OwnerHost {
+		return false
+	}
+	return !processAlive(s.OwnerPID)
+}
+
+// SessionAction is what launch-server does with an existing session for
+// the workshop it is asked to serve.
+type SessionAction int
+
+const (
+	// SessionStart starts a new container; there is no existing one.
+	SessionStart SessionAction = iota
+	// SessionReattach serves the running container again.
+	SessionReattach
+	// SessionReplace removes the existing container and starts a new one.
+	SessionReplace
+	// SessionAbort leaves the existing container alone and exits.
+	SessionAbort
+)
+
+// ExistingSessionAction decides what to do with the workshop's existing
+// session. ask poses a yes/no question with a default answer. A running
+// session is offered for reattaching; if that is declined it is only
+// replaced when its runner is gone or the user agrees, since another
+// fortihugorunner may still be serving it. Stopped containers are replaced.
+func ExistingSessionAction(session *Session, url string, ask func(question string, defaultYes bool) bool) SessionAction {
+	switch {
+	case session == nil:
+		return SessionStart
+	case !session.Running():
+		return SessionReplace
+	case ask(fmt.Sprintf("A session for this workshop is already running at %s (container %s). Reattach to it?", url, session.Name), true):
+		return SessionReattach
+	case session.Orphaned():
+		return SessionReplace
+	case ask(fmt.Sprintf("Container %s may belong to another running fortihugorunner. Stop it and start a new session?", session.Name), false):
+		return SessionReplace
+	}
+	return SessionAbort
+}
+
+// ContainerName returns the deterministic container name for a workshop
+// directory: its base name plus a short hash of the absolute path, so two
+// checkouts with the same folder name do not collide.
+func ContainerName(workshopDir string) string {
+	sum := sha256.Sum256([]byte(workshopDir))
+	base := strings.ToLower(filepath.Base(workshopDir))
+	base = strings.Trim(invalidNameChars.ReplaceAllString(base, "-"), "-._")
+	if base == "" {
+		base = "workshop"
+	}
+	return containerNamePrefix + base + "-" + hex.EncodeToString(sum[:4])
+}
+
+// sessionLabels returns the labels identifying cfg's container.
+func sessionLabels(cfg ServerConfig) map[string]string {
+	host, _ := os.Hostname()
+	return map[string]string{
+		LabelWorkshop: cfg.WatchDir,
+		LabelVersion:  version.Version,
+		LabelPort:     cfg.HostPort,
+		LabelImage:    cfg.DockerImage,
+		LabelHost:     host,
+		LabelPID:      strconv.Itoa(os.Getpid()),
+	}
+}
+
+// ListSessions returns runner-managed containers, including stopped ones
+// when all is set.
+func ListSessions(ctx context.Context, cli client.ContainerAPIClient, all bool) ([]Session, error) {
+	list, err := cli.ContainerList(ctx, client.ContainerListOptions{
+		All:     all,
+		Filters: make(client.Filters).Add("label", LabelWorkshop),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(list.Items))
+	for _, c := range list.Items {
+		sessions = append(sessions, sessionFromSummary(c))
+	}
+	return sessions, nil
+}
+
+// FindStaleSessions returns orphaned containers of other workshops that
+// publish cfg's host port and would block starting a new session.
+func FindStaleSessions(ctx context.Context, cli client.ContainerAPIClient, cfg ServerConfig) ([]Session, error) {
+	sessions, err := ListSessions(ctx, cli, false)
+	if err != nil {
+		return nil, err
+	}
+	var stale []Session
+	for _, s := range sessions {
+		if s.Workshop != cfg.WatchDir && s.HostPort == cfg.HostPort && s.Orphaned() {
+			stale = append(stale, s)
+		}
+	}
+	return stale, nil
+}
+
+// FindWorkshopSession returns the container for workshopDir, running or not,
+// or nil if there is none.
+func FindWorkshopSession(ctx context.Context, cli client.ContainerAPIClient, workshopDir string) (*Session, error) {
+	list, err := cli.ContainerList(ctx, client.ContainerListOptions{
+		All:     true,
+		Filters: make(client.Filters).Add("label", LabelWorkshop+"="+workshopDir),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Items) == 0 {
+		return nil, nil
+	}
+	s := sessionFromSummary(list.Items[0])
+	return &s, nil
+}
+
+// MinIDPrefix is the shortest container ID prefix ResolveSession accepts.
+const MinIDPrefix = 4
+
+// ResolveSession finds the runner container for arg: a workshop directory,
+// a container name, a container ID or an ID prefix of at least MinIDPrefix
+// characters that matches one container only.
+func ResolveSession(ctx context.Context, cli client.ContainerAPIClient, arg string) (*Session, error) {
+	if abs, err := filepath.Abs(arg); err == nil {
+		session, err := FindWorkshopSession(ctx, cli, abs)
+		if err != nil {
+			return nil, err
+		}
+		if session != nil {
+			return session, nil
+		}
+	}
+	sessions, err := ListSessions(ctx, cli, true)
+	if err != nil {
+		return nil, err
+	}
+	var matches []Session
+	for _, s := range sessions {
+		if s.Name == arg || s.ContainerID == arg {
+			return &s, nil
+		}
+		if strings.HasPrefix(s.ContainerID, arg) {
+			matches = append(matches, s)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return nil, fmt.Errorf("no fortihugorunner container found for %q", arg)
+	case len(arg) < MinIDPrefix:
+		return nil, fmt.Errorf("container ID prefix %q is too short; use at least %d characters or the container name", arg, MinIDPrefix)
+	case len(matches) > 1:
+		var names []string
+		for _, s := range matches {
+			names = append(names, s.Name)
+		}
+		return nil, fmt.Errorf("container ID prefix %q matches %d containers (%s); use a longer prefix or the container name", arg, len(matches), strings.Join(names, ", "))
+	}
+	return &matches[0], nil
+}
+
+// ShortID shortens a container or image ID to the 12 characters docker
+// shows, dropping the sha256: prefix of image IDs.
+func ShortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// RepoDigest returns the registry digest of the image the session runs,
+// preferring the one recorded for the session's image repository. It
+// returns "" for an image that was built locally and never pushed or
+// pulled, or one that has been removed.
+func (s Session) RepoDigest(ctx context.Context, cli client.ImageAPIClient) string {
+	ref := s.ImageID
+	if ref == "" {
+		ref = s.Image
+	}
+	img, err := cli.ImageInspect(ctx, ref)
+	if err != nil || len(img.RepoDigests) == 0 {
+		return ""
+	}
+	want, _ := ParseImageRef(s.Image)
+	digest := ""
+	for _, repoDigest := range img.RepoDigests {
+		r, err := ParseImageRef(repoDigest)
+		if err != nil || r.Digest == "" {
+			continue
+		}
+		if r.Name() == want.Name() {
+			return r.Digest
+		}
+		if digest == "" {
+			digest = r.Digest
+		}
+	}
+	return digest
+}
+
+func sessionFromSummary(c container.Summary) Session {
+	s := Session{
+		ContainerID: c.ID,
+		Workshop:    c.Labels[LabelWorkshop],
+		Image:       c.Labels[LabelImage],
+		ImageID:     c.ImageID,
+		Version:     c.Labels[LabelVersion],
+		HostPort:    c.Labels[LabelPort],
+		State:       string(c.State),
+		Status:      c.Status,
+		Created:     time.Unix(c.Created, 0),
+		Size:        c.SizeRw,
+		OwnerHost:   c.Labels[LabelHost],
+	}
+	s.OwnerPID, _ = strconv.Atoi(c.Labels[LabelPID])
+	if len(c.Names) > 0 {
+		s.Name = strings.TrimPrefix(c.Names[0], "/")
+	}
+	if s.Image == "" {
+		s.Image = c.Image
+	}
+	for _, p := range c.Ports {
+		if p.PublicPort != 0 {
+			s.BindAddress = p.IP
+			break
+		}
+	}
+	return s
+}
+
+// ServerConfig returns cfg adjusted to the port and address the session's
+// container actually publishes on.
+func (s Session) ServerConfig(cfg ServerConfig) ServerConfig {
+	if s.HostPort != "" {
+		cfg.HostPort = s.HostPort
+	}
+	if s.BindAddress.IsValid() {
+		cfg.BindAddress = s.BindAddress
+	}
+	return cfg
+}
@@ -1,95 +1,150 @@
 package dockerinternal
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
-func fetchManifestDigestWithToken(url, token string) (string, error) {
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-	req.Header.Set("User-Agent", "Go-Docker-Client/1.0")
-	if token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
+const registryUserAgent = "fortihugorunner"
+
+// ErrRegistryAuth is returned when a registry rejects the request and no
+// usable credentials are available.
+var ErrRegistryAuth = errors.New("registry authentication failed")
+
+// authChallenge is a parsed WWW-Authenticate header.
+type authChallenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+// parseAuthChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+// Quoted values may contain commas, as scopes do.
+func parseAuthChallenge(header string) (authChallenge, error) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	if scheme == "" {
+		return authChallenge{}, fmt.Errorf("missing WWW-Authenticate challenge")
+	}
+	c := authChallenge{Scheme: strings.ToLower(scheme), Params: map[string]string{}}
+	for rest = strings.TrimSpace(rest); rest != ""; {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				return authChallenge{}, fmt.Errorf("unterminated quoted value in challenge %q", header)
+			}
+			c.Params[key] = value[1 : end+1]
+			rest = value[end+2:]
+		} else {
+			v, after, _ := strings.Cut(value, ",")
+			c.Params[key] = strings.TrimSpace(v)
+			rest = "," + after
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest), ","))
 	}
+	return c, nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// authorize answers a registry challenge and returns the Authorization header
+// value for the retried request.
+func (r *Registry) authorize(ctx context.Context, httpClient *http.Client, header string, ref ImageRef) (string, error) {
+	challenge, err := parseAuthChallenge(header)
 	if err != nil {
 		return "", err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusUnauthorized {
-		return "", fmt.Errorf("401 Unauthorized")
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to fetch manifest: %s", resp.Status)
+	username, password, err := r.credentials(ref)
+	if err != nil {
+		return "", err
 	}
 
-	digest := resp.Header.Get("Docker-Content-Digest")
-	if digest != "" {
-		return digest, nil
+	switch challenge.Scheme {
+	case "basic":
+		if username == "" {
+			return "", fmt.Errorf("%w: %s requires a username and password", ErrRegistryAuth, ref.Domain)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case "bearer":
+		token, err := fetchBearerToken(ctx, httpClient, challenge, ref, username, password)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
 	}
+	return "", fmt.Errorf("unsupported registry auth scheme %q", challenge.Scheme)
+}
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, resp.Body); err != nil {
-		return "", fmt.Errorf("failed to read manifest body for hashing: %v", err)
+func (r *Registry) credentials(ref ImageRef) (string, string, error) {
+	if r.Credentials == nil {
+		return "", "", nil
 	}
-
-	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+	return r.Credentials(ref.Domain)
 }
 
-func getRegistryToken(manifestURL string) (string, error) {
-	// First request to get the WWW-Authenticate header
-	req, _ := http.NewRequest("GET", manifestURL, nil)
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-	req.Header.Set("User-Agent", "Go-Docker-Client/1.0")
-
-	resp, err := http.DefaultClient.Do(req)
+// fetchBearerToken requests a pull token from the challenge's realm, using
+// Basic auth when credentials are available.
+func fetchBearerToken(ctx context.Context, httpClient *http.Client, c authChallenge, ref ImageRef, username, password string) (string, error) {
+	realm := c.Params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("bearer challenge without realm")
+	}
+	tokenURL, err := url.Parse(realm)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("invalid token realm %q: %w", realm, err)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusUnauthorized {
-		return "", fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	scope := c.Params["scope"]
+	if scope == "" {
+		scope = "repository:" + ref.Path + ":pull"
 	}
-
-	authHeader := resp.Header.Get("WWW-Authenticate")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return "", fmt.Errorf("unexpected auth header format: %s", authHeader)
+	q := tokenURL.Query()
+	if service := c.Params["service"]; service != "" {
+		q.Set("service", service)
 	}
+	q.Set("scope", scope)
+	tokenURL.RawQuery = q.Encode()
 
-	// Parse realm, service, scope
-	params := map[string]string{}
-	for _, field := range strings.Split(authHeader[len("Bearer "):], ",") {
-		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
-		if len(kv) == 2 {
-			k := strings.Trim(kv[0], `"`)
-			v := strings.Trim(kv[1], `"`)
-			params[k] = v
-		}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", err
 	}
-
-	tokenURL := fmt.Sprintf("%s?service=%s&scope=%s", params["realm"], params["service"], params["scope"])
-	tokenResp, err := http.Get(tokenURL)
+	req.Header.Set("User-Agent", registryUserAgent)
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return "", err
 	}
-	defer tokenResp.Body.Close()
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return "", fmt.Errorf("%w: token request to %s: %s", ErrRegistryAuth, tokenURL.Host, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &RegistryError{URL: tokenURL.String(), StatusCode: resp.StatusCode, Status: resp.Status}
+	}
 
 	var tokenData struct {
-		Token string `json:"token"`
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
 	}
-	if err := json.NewDecoder(tokenResp.Body).Decode(&tokenData); err != nil {
-		return "", err
+	if err := json.NewDecoder(resp.Body).Decode(&tokenData); err != nil {
+		return "", fmt.Errorf("failed to decode registry token: %w", err)
 	}
-
-	return tokenData.Token, nil
+	if tokenData.Token != "" {
+		return tokenData.Token, nil
+	}
+	if tokenData.AccessToken != "" {
+		return tokenData.AccessToken, nil
+	}
+	return "", fmt.Errorf("registry token response from %s has no token", tokenURL.Host)
 }
@@ -0,0 +1,163 @@
+package dockerinternal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// NotifyWatcher watches a directory tree with fsnotify. fsnotify is not
+// recursive, so every directory is registered individually; the set is kept
+// current as directories are created, removed or renamed while running.
+type NotifyWatcher struct {
+	root    string
+	ignore  *IgnoreMatcher
+	fs      *fsnotify.Watcher
+	changes chan string
+	errors  chan error
+	done    chan struct{}
+
+	mu   sync.Mutex
+	dirs map[string]bool
+}
+
+// NewNotifyWatcher registers root and every non-ignored directory below it
+// and starts delivering changed paths on Changes.
+func NewNotifyWatcher(root string, ignore *IgnoreMatcher) (*NotifyWatcher, error) {
+	fs, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &NotifyWatcher{
+		root:    root,
+		ignore:  ignore,
+		fs:      fs,
+		changes: make(chan string),
+		errors:  make(chan error),
+		done:    make(chan struct{}),
+		dirs:    map[string]bool{},
+	}
+	w.addTree(root)
+	go w.run()
+	return w, nil
+}
+
+// Changes delivers the path of every created, written, removed or renamed
+// file or directory that is not ignored.
+func (w *NotifyWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+// Errors delivers errors reported by the fsnotify backend.
+func (w *NotifyWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+// Close stops watching. Changes and Errors are closed once the event loop
+// has exited.
+func (w *NotifyWatcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+		close(w.done)
+	}
+	return w.fs.Close()
+}
+
+// WatchedDirs returns the currently registered directories, sorted.
+func (w *NotifyWatcher) WatchedDirs() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dirs := make([]string, 0, len(w.dirs))
+	for dir := range w.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+func (w *NotifyWatcher) run() {
+	defer close(w.changes)
+	defer close(w.errors)
+	for {
+		select {
+		case event, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			if isIgnoredEvent(w.ignore, w.root, event.Name) {
+				continue
+			}
+			switch {
+			case event.Op&fsnotify.Create != 0:
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					w.addTree(event.Name)
+				}
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				w.removeTree(event.Name)
+			}
+			select {
+			case w.changes <- event.Name:
+			case <-w.done:
+				return
+			}
+		case err, ok := <-w.fs.Errors:
+			if !ok {
+				return
+			}
+			select {
+			case w.errors <- err:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// addTree registers dir and every non-ignored directory below it.
+func (w *NotifyWatcher) addTree(dir string) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// The directory may have vanished again before we got to it.
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if isIgnored(w.ignore, w.root, path, true) {
+			return filepath.SkipDir
+		}
+		if err := w.fs.Add(path); err != nil {
+			fmt.Printf("Error watching directory %s: %v\n", path, err)
+			return nil
+		}
+		w.mu.Lock()
+		w.dirs[path] = true
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+// removeTree unregisters path and any watched directories below it. The
+// kernel drops watches on deleted directories by itself, so errors from
+// fsnotify are expected and ignored.
+func (w *NotifyWatcher) removeTree(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prefix := path + string(filepath.Separator)
+	for dir := range w.dirs {
+		if dir == path || strings.HasPrefix(dir, prefix) {
+			_ = w.fs.Remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package dockerinternal
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DefaultPollInterval is how often PollWatcher rescans the tree.
+const DefaultPollInterval = time.Second
+
+// PollWatcher detects changes by periodically snapshotting the modification
+// time and size of every file under root. It works where inotify events do
+// not arrive, such as /mnt/ drives under WSL2, SMB shares and some
+// virtiofs-backed VMs.
+type PollWatcher struct {
+	root     string
+	ignore   *IgnoreMatcher
+	interval time.Duration
+	changes  chan string
+	errors   chan error
+	done     chan struct{}
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+}
+
+// NewPollWatcher takes an initial snapshot of root and starts rescanning it
+// every interval.
+func NewPollWatcher(root string, ignore *IgnoreMatcher, interval time.Duration) (*PollWatcher, error) {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	w := &PollWatcher{
+		root:     root,
+		ignore:   ignore,
+		interval: interval,
+		changes:  make(chan string),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+	snapshot, err := w.scan()
+	if err != nil {
+		return nil, err
+	}
+	go w.run(snapshot)
+	return w, nil
+}
+
+// Changes delivers the path of every file or directory that appeared,
+// disappeared or whose modification time or size changed between scans.
+func (w *PollWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+// Errors delivers errors encountered while scanning.
+func (w *PollWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+// Close stops polling.
+func (w *PollWatcher) Close() error {
+	select {
+	case <-w.done:
+	default:
+		close(w.done)
+	}
+	return nil
+}
+
+func (w *PollWatcher) run(previous map[string]fileState) {
+	defer close(w.changes)
+	defer close(w.errors)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			current, err := w.scan()
+			if err != nil {
+				select {
+				case w.errors <- err:
+				case <-w.done:
+					return
+				}
+				continue
+			}
+			for _, path := range diffSnapshots(previous, current) {
+				select {
+				case w.changes <- path:
+				case <-w.done:
+					return
+				}
+			}
+			previous = current
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *PollWatcher) scan() (map[string]fileState, error) {
+	snapshot := map[string]fileState{}
+	err := filepath.Walk(w.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path != w.root {
+				// Removed between listing and stat; the next scan settles it.
+				return nil
+			}
+			return err
+		}
+		if isIgnored(w.ignore, w.root, path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		state := fileState{isDir: info.IsDir()}
+		if !state.isDir {
+			state.modTime = info.ModTime()
+			state.size = info.Size()
+		}
+		snapshot[path] = state
+		return nil
+	})
+	return snapshot, err
+}
+
+// diffSnapshots returns the sorted paths that were added, removed or modified.
+func diffSnapshots(previous, current map[string]fileState) []string {
+	var changed []string
+	for path, state := range current {
+		if prev, ok := previous[path]; !ok || prev != state {
+			changed = append(changed, path)
+		}
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
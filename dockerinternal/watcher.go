@@ -2,17 +2,168 @@ package dockerinternal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
+)
+
+// FileWatcher delivers the paths of changed files under a watch directory.
+type FileWatcher interface {
+	Changes() <-chan string
+	Errors() <-chan error
+	Close() error
+}
+
+// WatchBackend selects how file changes are detected.
+type WatchBackend string
 
-	"github.com/moby/moby/client"
-	"github.com/fsnotify/fsnotify"
+const (
+	// WatchBackendAuto uses polling on WSL2 /mnt/ drives and fsnotify elsewhere.
+	WatchBackendAuto WatchBackend = "auto"
+	// WatchBackendNotify uses OS file change notifications via fsnotify.
+	WatchBackendNotify WatchBackend = "notify"
+	// WatchBackendPoll rescans the tree at a fixed interval.
+	WatchBackendPoll WatchBackend = "poll"
 )
 
+// ParseWatchBackend validates a --watch-backend value.
+func ParseWatchBackend(s string) (WatchBackend, error) {
+	switch b := WatchBackend(strings.ToLower(s)); b {
+	case WatchBackendAuto, WatchBackendNotify, WatchBackendPoll:
+		return b, nil
+	}
+	return "", fmt.Errorf("invalid watch backend %q: must be one of auto, notify or poll", s)
+}
+
+// ResolveWatchBackend turns WatchBackendAuto into a concrete backend. Under
+// WSL2, inotify events are not delivered for Windows drives mounted at /mnt/.
+func ResolveWatchBackend(backend WatchBackend, watchDir string, isWSL bool) WatchBackend {
+	if backend != WatchBackendAuto {
+		return backend
+	}
+	if isWSL && strings.HasPrefix(filepath.ToSlash(watchDir), "/mnt/") {
+		return WatchBackendPoll
+	}
+	return WatchBackendNotify
+}
+
+// NewFileWatcher creates the watcher for cfg's backend.
+func NewFileWatcher(cfg ServerConfig, ignore *IgnoreMatcher) (FileWatcher, error) {
+	switch ResolveWatchBackend(cfg.WatchBackend, cfg.WatchDir, IsWSL2()) {
+	case WatchBackendPoll:
+		fmt.Printf("Polling for file changes every %s\n", cfg.PollInterval)
+		return NewPollWatcher(cfg.WatchDir, ignore, cfg.PollInterval)
+	default:
+		return NewNotifyWatcher(cfg.WatchDir, ignore)
+	}
+}
+
+// WatchMode selects how WatchAndRestart reacts to file changes.
+type WatchMode string
+
+const (
+	// WatchModeHugo relies on Hugo's own livereload and only restarts the
+	// container when the site config or theme files change.
+	WatchModeHugo WatchMode = "hugo"
+	// WatchModeRestart stops and recreates the container on every change.
+	WatchModeRestart WatchMode = "restart"
+	// WatchModeRebuild runs a Hugo build inside the running container.
+	WatchModeRebuild WatchMode = "rebuild"
+)
+
+// WatchModes lists the accepted --watch-mode values.
+var WatchModes = []WatchMode{WatchModeHugo, WatchModeRestart, WatchModeRebuild}
+
+// WatchAction is what WatchAndRestart does once a batch of changes settles.
+type WatchAction int
+
+const (
+	WatchActionNone WatchAction = iota
+	WatchActionRestart
+	WatchActionRebuild
+)
+
+func (a WatchAction) String() string {
+	switch a {
+	case WatchActionRestart:
+		return "restart"
+	case WatchActionRebuild:
+		return "rebuild"
+	default:
+		return "none"
+	}
+}
+
+// hugoConfigFiles are site config files Hugo reads at startup. hugo.toml is
+// bind-mounted as a single file with --mount-toml, which does not pick up
+// editors that replace the file, so a change always needs a restart.
+var hugoConfigFiles = map[string]bool{
+	"hugo.toml":   true,
+	"hugo.yaml":   true,
+	"hugo.yml":    true,
+	"hugo.json":   true,
+	"config.toml": true,
+	"config.yaml": true,
+	"config.yml":  true,
+	"config.json": true,
+}
+
+// hugoRestartDirs are top-level directories whose contents Hugo only loads
+// on startup.
+var hugoRestartDirs = []string{"config", "themes"}
+
+// ParseWatchMode validates a --watch-mode value.
+func ParseWatchMode(s string) (WatchMode, error) {
+	for _, m := range WatchModes {
+		if WatchMode(strings.ToLower(s)) == m {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("invalid watch mode %q: must be one of hugo, restart or rebuild", s)
+}
+
+// SelectWatchAction decides what to do about the files changed under watchDir
+// during one debounce window.
+func SelectWatchAction(mode WatchMode, watchDir string, changed []string) WatchAction {
+	if len(changed) == 0 {
+		return WatchActionNone
+	}
+	if mode == WatchModeRestart {
+		return WatchActionRestart
+	}
+	for _, path := range changed {
+		if needsRestart(watchDir, path) {
+			return WatchActionRestart
+		}
+	}
+	if mode == WatchModeRebuild {
+		return WatchActionRebuild
+	}
+	return WatchActionNone
+}
+
+// needsRestart reports whether path is a Hugo config or theme file.
+func needsRestart(watchDir, path string) bool {
+	rel, err := filepath.Rel(watchDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if hugoConfigFiles[rel] {
+		return true
+	}
+	for _, dir := range hugoRestartDirs {
+		if rel == dir || strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // adjustPathForDockerWithOS converts paths for Windows/WSL2; on macOS (darwin) no change is needed.
 func AdjustPathForDockerWithOS(path, goos string, isWSL bool) string {
 	if goos == "darwin" {
@@ -41,44 +192,40 @@ func IsWSL2() bool {
 	return isWSL && runtime.GOOS == "linux"
 }
 
-func WatchAndRestart(ctx context.Context, cli *client.Client, cfg ServerConfig, containerID *string) {
-	watcher, err := fsnotify.NewWatcher()
+// WatchAndRestart reacts to file changes in the session's watch directory
+// until ctx is cancelled or the session shuts down.
+func WatchAndRestart(ctx context.Context, session *ServerSession) {
+	cfg := session.Config()
+	ignore, err := LoadIgnoreMatcher(cfg.WatchDir, cfg.WatchIgnore)
+	if err != nil {
+		fmt.Printf("Error loading watch ignore rules: %v\n", err)
+		return
+	}
+
+	watcher, err := NewFileWatcher(cfg, ignore)
 	if err != nil {
 		fmt.Printf("Error creating file watcher: %v\n", err)
 		return
 	}
 	defer watcher.Close()
 
-	// Add watchers recursively for subdirectories.
-	filepath.Walk(cfg.WatchDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			if err := watcher.Add(path); err != nil {
-				fmt.Printf("Error watching directory %s: %v\n", path, err)
-			}
-		}
-		return nil
-	})
-
-	fmt.Println("Watching for file changes in:", cfg.WatchDir)
+	fmt.Printf("Watching for file changes in: %s (watch mode: %s)\n", cfg.WatchDir, cfg.WatchMode)
 	debounceDuration := 2 * time.Second
 	var debounceTimer *time.Timer
+	var changed []string
 
 	for {
 		select {
-		case event, ok := <-watcher.Events:
+		case path, ok := <-watcher.Changes():
 			if !ok {
 				return
 			}
-			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove) != 0 {
-				fmt.Println("File change detected:", event.Name)
-				if debounceTimer != nil {
-					debounceTimer.Stop()
-				}
-				debounceTimer = time.NewTimer(debounceDuration)
+			fmt.Println("File change detected:", path)
+			changed = append(changed, path)
+			if debounceTimer != nil {
+				debounceTimer.Stop()
 			}
+			debounceTimer = time.NewTimer(debounceDuration)
 		case <-func() <-chan time.Time {
 			if debounceTimer != nil {
 				return debounceTimer.C
@@ -86,19 +233,31 @@ func WatchAndRestart(ctx context.Context, cli *client.Client, cfg ServerConfig,
 			ch := make(chan time.Time)
 			return ch
 		}():
-			fmt.Println("Restarting container due to file changes")
-			StopAndRemoveContainer(cli, *containerID)
-			newID, err := StartContainer(ctx, cli, cfg)
-			if err != nil {
-				fmt.Printf("Error restarting container: %v\n", err)
-			} else {
-				*containerID = newID
-				if err := AttachContainer(ctx, cli, newID); err != nil {
-					fmt.Printf("Error attaching to container: %v\n", err)
+			switch SelectWatchAction(cfg.WatchMode, cfg.WatchDir, changed) {
+			case WatchActionRestart:
+				fmt.Println("Restarting container due to file changes")
+				if err := session.Restart(ctx); err != nil {
+					if errors.Is(err, ErrSessionClosed) {
+						return
+					}
+					fmt.Printf("Error restarting container: %v\n", err)
+				} else if cfg.ReadyTimeout > 0 {
+					if err := session.WaitForReady(ctx); ctx.Err() == nil {
+						ReportReadiness(err, ServerURL(cfg))
+					}
+				}
+			case WatchActionRebuild:
+				fmt.Println("Rebuilding site due to file changes")
+				if err := session.Rebuild(ctx); err != nil {
+					if errors.Is(err, ErrSessionClosed) {
+						return
+					}
+					fmt.Printf("Error rebuilding site: %v\n", err)
 				}
 			}
+			changed = nil
 			debounceTimer = nil
-		case err, ok := <-watcher.Errors:
+		case err, ok := <-watcher.Errors():
 			if !ok {
 				return
 			}
@@ -108,3 +267,24 @@ func WatchAndRestart(ctx context.Context, cli *client.Client, cfg ServerConfig,
 		}
 	}
 }
+
+// isIgnored reports whether path, an absolute path under watchDir, matches
+// the ignore rules.
+func isIgnored(ignore *IgnoreMatcher, watchDir, path string, isDir bool) bool {
+	rel, err := filepath.Rel(watchDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return ignore.Match(rel, isDir)
+}
+
+// isIgnoredEvent checks an fsnotify event path. Removed paths can no longer be
+// stat'ed and may have been directories, e.g. a deleted node_modules/, so
+// they are ignored if either the file or the directory form matches.
+func isIgnoredEvent(ignore *IgnoreMatcher, watchDir, path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return isIgnored(ignore, watchDir, path, false) || isIgnored(ignore, watchDir, path, true)
+	}
+	return isIgnored(ignore, watchDir, path, info.IsDir())
+}
@@ -0,0 +1,80 @@
+package dockerinternal_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+)
+
+func TestParseBindAddress(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{"127.0.0.1", "127.0.0.1", false},
+		{"localhost", "127.0.0.1", false},
+		{"::1", "::1", false},
+		{"[::1]", "::1", false},
+		{"0.0.0.0", "0.0.0.0", false},
+		{"::", "::", false},
+		{"::ffff:127.0.0.1", "127.0.0.1", false},
+		{"203.0.113.7", "", true}, // TEST-NET-3, never assigned locally
+		{"fe80::1%eth0", "", true},
+		{"not-an-ip", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		addr, err := dockerinternal.ParseBindAddress(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBindAddress(%q): unexpected error state: %v", tt.input, err)
+			continue
+		}
+		if !tt.wantErr && addr.String() != tt.expected {
+			t.Errorf("ParseBindAddress(%q): expected %s, got %s", tt.input, tt.expected, addr)
+		}
+	}
+}
+
+func TestServerURL(t *testing.T) {
+	tests := []struct {
+		bind     string
+		expected string
+	}{
+		{"127.0.0.1", "http://localhost:1313/"},
+		{"0.0.0.0", "http://localhost:1313/"},
+		{"::", "http://localhost:1313/"},
+		{"::1", "http://[::1]:1313/"},
+		{"192.168.1.20", "http://192.168.1.20:1313/"},
+	}
+	for _, tt := range tests {
+		cfg := dockerinternal.ServerConfig{HostPort: "1313", BindAddress: netip.MustParseAddr(tt.bind)}
+		if result := dockerinternal.ServerURL(cfg); result != tt.expected {
+			t.Errorf("bind %s: expected %s, got %s", tt.bind, tt.expected, result)
+		}
+	}
+}
+
+func TestLANURLs(t *testing.T) {
+	loopback := dockerinternal.ServerConfig{HostPort: "1313", BindAddress: netip.MustParseAddr("127.0.0.1")}
+	if dockerinternal.SharesOnLAN(loopback) || len(dockerinternal.LANURLs(loopback)) != 0 {
+		t.Error("expected loopback binding not to be shared on the LAN")
+	}
+
+	lan := dockerinternal.ServerConfig{HostPort: "1313", BindAddress: netip.MustParseAddr("192.168.1.20")}
+	urls := dockerinternal.LANURLs(lan)
+	if len(urls) != 1 || urls[0] != "http://192.168.1.20:1313/" {
+		t.Errorf("expected single LAN URL, got %v", urls)
+	}
+
+	all := dockerinternal.ServerConfig{HostPort: "1313", BindAddress: netip.MustParseAddr("0.0.0.0")}
+	if !dockerinternal.SharesOnLAN(all) {
+		t.Error("expected 0.0.0.0 to be shared on the LAN")
+	}
+	for _, url := range dockerinternal.LANURLs(all) {
+		if url == "http://127.0.0.1:1313/" {
+			t.Errorf("loopback address listed as LAN URL: %v", url)
+		}
+	}
+}
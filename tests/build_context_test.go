@@ -0,0 +1,170 @@
+package dockerinternal_test
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+)
+
+// readTar returns the headers of a tar stream by entry name.
+func readTar(t *testing.T, r io.Reader) map[string]*tar.Header {
+	t.Helper()
+	entries := map[string]*tar.Header{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("reading build context: %v", err)
+		}
+		entries[hdr.Name] = hdr
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreateTarball(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Dockerfile":        "FROM scratch\n",
+		".dockerignore":     "# build output\n/public\n.git\n**/*.mp4\n!media/intro.mp4\nDockerfile\n.dockerignore\n",
+		"hugo.toml":         "title = 'x'\n",
+		"content/_index.md": "# Workshop\n",
+		"public/index.html": "<html></html>\n",
+		".git/HEAD":         "ref: refs/heads/main\n",
+		"media/lab.mp4":     "big",
+		"media/intro.mp4":   "small",
+		"scripts/setup.sh":  "#!/bin/sh\n",
+	})
+	if err := os.Chmod(filepath.Join(dir, "scripts", "setup.sh"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "static"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	symlinks := runtime.GOOS != "windows"
+	if symlinks {
+		if err := os.Symlink(filepath.Join("content", "_index.md"), filepath.Join(dir, "index.md")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rc, err := dockerinternal.CreateTarball(dir, "Dockerfile")
+	if err != nil {
+		t.Fatalf("CreateTarball: %v", err)
+	}
+	defer rc.Close()
+	entries := readTar(t, rc)
+
+	for _, want := range []string{"Dockerfile", ".dockerignore", "hugo.toml", "content/", "content/_index.md", "media/", "media/intro.mp4", "scripts/setup.sh", "static/"} {
+		if _, ok := entries[want]; !ok {
+			t.Errorf("expected %s in the build context", want)
+		}
+	}
+	for _, excluded := range []string{"public/", "public/index.html", ".git/", ".git/HEAD", "media/lab.mp4"} {
+		if _, ok := entries[excluded]; ok {
+			t.Errorf("expected %s to be excluded by .dockerignore", excluded)
+		}
+	}
+
+	if hdr := entries["static/"]; hdr != nil && hdr.Typeflag != tar.TypeDir {
+		t.Errorf("expected static/ to be a directory entry, got type %c", hdr.Typeflag)
+	}
+	if hdr := entries["scripts/setup.sh"]; hdr != nil && hdr.FileInfo().Mode().Perm()&0o111 == 0 {
+		t.Errorf("expected setup.sh to stay executable, got mode %v", hdr.FileInfo().Mode())
+	}
+	if hdr := entries["hugo.toml"]; hdr != nil && runtime.GOOS != "windows" && hdr.FileInfo().Mode().Perm()&0o111 != 0 {
+		t.Errorf("expected hugo.toml not to be executable, got mode %v", hdr.FileInfo().Mode())
+	}
+	if symlinks {
+		hdr := entries["index.md"]
+		if hdr == nil || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "content/_index.md" {
+			t.Errorf("expected index.md as a symlink to content/_index.md, got %+v", hdr)
+		}
+	}
+}
+
+func TestCreateTarballWithoutDockerIgnore(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"Dockerfile": "FROM scratch\n", "docs/a.md": "a"})
+
+	rc, err := dockerinternal.CreateTarball(dir, "Dockerfile")
+	if err != nil {
+		t.Fatalf("CreateTarball: %v", err)
+	}
+	defer rc.Close()
+	var names []string
+	for name := range readTar(t, rc) {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	if want := []string{"Dockerfile", "docs/", "docs/a.md"}; !slices.Equal(names, want) {
+		t.Errorf("expected %v, got %v", want, names)
+	}
+}
+
+func TestCreateTarballKeepsDockerfileInExcludedDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".dockerignore":     "docker\n",
+		"docker/Dockerfile": "FROM scratch\n",
+		"docker/notes.txt":  "x",
+	})
+
+	rc, err := dockerinternal.CreateTarball(dir, filepath.Join("docker", "Dockerfile"))
+	if err != nil {
+		t.Fatalf("CreateTarball: %v", err)
+	}
+	defer rc.Close()
+	entries := readTar(t, rc)
+	if _, ok := entries["docker/Dockerfile"]; !ok {
+		t.Error("expected the Dockerfile to be sent even though its directory is excluded")
+	}
+	if _, ok := entries["docker/notes.txt"]; ok {
+		t.Error("expected docker/notes.txt to be excluded")
+	}
+}
+
+func TestCreateTarballInvalidPattern(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{".dockerignore": "[\n"})
+	if _, err := dockerinternal.CreateTarball(dir, "Dockerfile"); err == nil {
+		t.Error("expected an error for an invalid .dockerignore pattern")
+	}
+}
+
+func TestCreateTarballCloseEarly(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"Dockerfile": "FROM scratch\n", "big.bin": string(make([]byte, 1<<20))})
+
+	rc, err := dockerinternal.CreateTarball(dir, "Dockerfile")
+	if err != nil {
+		t.Fatalf("CreateTarball: %v", err)
+	}
+	if _, err := rc.Read(make([]byte, 512)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if err := rc.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package dockerinternal_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// traceVertex encodes a BuildKit Vertex message.
+type traceVertex struct {
+	digest, name, err          string
+	cached, started, completed bool
+}
+
+// traceMessage returns a moby.buildkit.trace stream message carrying a
+// StatusResponse with the given vertexes and logs (vertex digest → text).
+func traceMessage(t *testing.T, vertices []traceVertex, logs [][2]string) string {
+	t.Helper()
+	var status []byte
+	for _, v := range vertices {
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, v.digest)
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, v.name)
+		if v.cached {
+			b = protowire.AppendTag(b, 4, protowire.VarintType)
+			b = protowire.AppendVarint(b, 1)
+		}
+		// Timestamps are messages; an empty one is enough to be set.
+		if v.started {
+			b = protowire.AppendTag(b, 5, protowire.BytesType)
+			b = protowire.AppendBytes(b, nil)
+		}
+		if v.completed {
+			b = protowire.AppendTag(b, 6, protowire.BytesType)
+			b = protowire.AppendBytes(b, nil)
+		}
+		if v.err != "" {
+			b = protowire.AppendTag(b, 7, protowire.BytesType)
+			b = protowire.AppendString(b, v.err)
+		}
+		status = protowire.AppendTag(status, 1, protowire.BytesType)
+		status = protowire.AppendBytes(status, b)
+	}
+	for _, l := range logs {
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, l[0])
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, 1)
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, l[1])
+		status = protowire.AppendTag(status, 3, protowire.BytesType)
+		status = protowire.AppendBytes(status, b)
+	}
+	aux, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return `{"id":"moby.buildkit.trace","aux":` + string(aux) + "}\n"
+}
+
+func TestDisplayBuildOutputClassicFailure(t *testing.T) {
+	stream := `{"stream":"Step 1/2 : FROM docker.io/hugomods/hugo:std\n"}
+{"stream":" ---> 0123abcd\n"}
+{"stream":"Step 2/2 : RUN hugo --minify\n"}
+{"stream":" ---> Running in 4567\n"}
+{"stream":"Error: module \"hugo-theme\" not found"}
+{"stream":"\n"}
+{"errorDetail":{"code":1,"message":"The command '/bin/sh -c hugo --minify' returned a non-zero code: 1"},"error":"The command '/bin/sh -c hugo --minify' returned a non-zero code: 1"}
+`
+	var out bytes.Buffer
+	err := dockerinternal.DisplayBuildOutput(strings.NewReader(stream), &out)
+	var buildErr *dockerinternal.BuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("expected a BuildError, got %v", err)
+	}
+	if buildErr.Step != "RUN hugo --minify" {
+		t.Errorf("expected the failing step, got %q", buildErr.Step)
+	}
+	wantLog := []string{" ---> Running in 4567", `Error: module "hugo-theme" not found`}
+	if !slices.Equal(buildErr.Log, wantLog) {
+		t.Errorf("expected log %q, got %q", wantLog, buildErr.Log)
+	}
+	var streamErr *dockerinternal.StreamError
+	if !errors.As(err, &streamErr) || streamErr.Code != 1 {
+		t.Errorf("expected the daemon's error to be wrapped, got %v", err)
+	}
+	if !strings.Contains(out.String(), "Step 2/2 : RUN hugo --minify\n") {
+		t.Errorf("expected the build output to be printed, got %q", out.String())
+	}
+}
+
+func TestDisplayBuildOutputBuildKit(t *testing.T) {
+	base := traceVertex{digest: "sha256:base", name: "[base 1/1] FROM docker.io/hugomods/hugo:std"}
+	run := traceVertex{digest: "sha256:run", name: "[prod 2/2] RUN hugo --minify"}
+
+	cachedBase := base
+	cachedBase.cached, cachedBase.started, cachedBase.completed = true, true, true
+	startedRun := run
+	startedRun.started = true
+	failedRun := startedRun
+	failedRun.completed = true
+	failedRun.err = `process "/bin/sh -c hugo --minify" did not complete successfully: exit code: 1`
+
+	tests := []struct {
+		name       string
+		finalError bool
+	}{
+		{"with error message", true},
+		{"trace only", false},
+	}
+	for _, tt := range tests {
+		stream := traceMessage(t, []traceVertex{cachedBase, startedRun}, nil) +
+			traceMessage(t, nil, [][2]string{{"sha256:run", "Start building sites … \nError: module \"hugo-theme\" "}, {"sha256:run", "not found\n"}}) +
+			traceMessage(t, []traceVertex{failedRun}, nil)
+		if tt.finalError {
+			stream += `{"errorDetail":{"message":"failed to solve: exit code: 1"},"error":"failed to solve: exit code: 1"}` + "\n"
+		}
+
+		var out bytes.Buffer
+		err := dockerinternal.DisplayBuildOutput(strings.NewReader(stream), &out)
+		var buildErr *dockerinternal.BuildError
+		if !errors.As(err, &buildErr) {
+			t.Fatalf("%s: expected a BuildError, got %v", tt.name, err)
+		}
+		if buildErr.Step != run.name {
+			t.Errorf("%s: expected step %q, got %q", tt.name, run.name, buildErr.Step)
+		}
+		wantLog := []string{"Start building sites … ", `Error: module "hugo-theme" not found`}
+		if !slices.Equal(buildErr.Log, wantLog) {
+			t.Errorf("%s: expected log %q, got %q", tt.name, wantLog, buildErr.Log)
+		}
+
+		want := `#1 [base 1/1] FROM docker.io/hugomods/hugo:std
+#1 CACHED
+#2 [prod 2/2] RUN hugo --minify
+#2 Start building sites … 
+#2 Error: module "hugo-theme" not found
+#2 ERROR: process "/bin/sh -c hugo --minify" did not complete successfully: exit code: 1
+`
+		if out.String() != want {
+			t.Errorf("%s: unexpected output:\n%s\nwant:\n%s", tt.name, out.String(), want)
+		}
+	}
+}
+
+func TestDisplayBuildOutputSuccess(t *testing.T) {
+	done := traceVertex{digest: "sha256:run", name: "[prod 2/2] RUN hugo", started: true, completed: true}
+	stream := traceMessage(t, []traceVertex{done}, nil) + `{"aux":{"ID":"sha256:0123abcd"}}` + "\n"
+
+	var out bytes.Buffer
+	if err := dockerinternal.DisplayBuildOutput(strings.NewReader(stream), &out); err != nil {
+		t.Fatalf("DisplayBuildOutput: %v", err)
+	}
+	if want := "#1 [prod 2/2] RUN hugo\n#1 DONE\n"; out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+}
+
+func TestBuildErrorMessage(t *testing.T) {
+	err := &dockerinternal.BuildError{Step: "RUN hugo", Err: errors.New("exit code: 1")}
+	if want := `build step "RUN hugo" failed: exit code: 1`; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+	err.Step = ""
+	if want := "build failed: exit code: 1"; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+}
@@ -0,0 +1,204 @@
+package dockerinternal_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"fortihugorunner/config"
+	"github.com/spf13/pflag"
+)
+
+// configDirs gives the test its own user config directory and returns the
+// user config path and a workshop directory nested below the project root.
+func configDirs(t *testing.T) (userPath, root, workshop string) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	for _, key := range []string{"host-port", "watch-dir", "mount-toml", "env"} {
+		unsetEnv(t, config.EnvName(key))
+	}
+
+	root = t.TempDir()
+	workshop = filepath.Join(root, "content", "chapter1")
+	if err := os.MkdirAll(workshop, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return config.UserFilePath(), root, workshop
+}
+
+// unsetEnv unsets name for the duration of the test.
+func unsetEnv(t *testing.T, name string) {
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigEnvName(t *testing.T) {
+	for key, want := range map[string]string{
+		"host-port":        "FORTIHUGORUNNER_HOST_PORT",
+		"watch-dir":        "FORTIHUGORUNNER_WATCH_DIR",
+		"env":              "FORTIHUGORUNNER_ENV",
+		"digest-cache-ttl": "FORTIHUGORUNNER_DIGEST_CACHE_TTL",
+	} {
+		if got := config.EnvName(key); got != want {
+			t.Errorf("EnvName(%q): expected %s, got %s", key, want, got)
+		}
+	}
+}
+
+func TestFindProjectFile(t *testing.T) {
+	_, root, workshop := configDirs(t)
+	if got := config.FindProjectFile(workshop); got != "" {
+		t.Errorf("expected no project file, got %s", got)
+	}
+
+	want := filepath.Join(root, config.ProjectFileName)
+	writeConfig(t, want, "host-port: 2000\n")
+	if got := config.FindProjectFile(workshop); got != want {
+		t.Errorf("expected %s from a subdirectory, got %q", want, got)
+	}
+
+	// The nearest file wins.
+	nearer := filepath.Join(workshop, config.ProjectFileName)
+	writeConfig(t, nearer, "host-port: 3000\n")
+	if got := config.FindProjectFile(workshop); got != nearer {
+		t.Errorf("expected the nearer %s, got %q", nearer, got)
+	}
+}
+
+func TestConfigLookupPrecedence(t *testing.T) {
+	userPath, root, workshop := configDirs(t)
+	projectPath := filepath.Join(root, config.ProjectFileName)
+	writeConfig(t, userPath, "host-port: 1000\nmount-toml: true\nenv: admin-dev\n")
+	writeConfig(t, projectPath, "host-port: 2000\nlaunch-server:\n  host-port: 2100\n")
+	t.Setenv("FORTIHUGORUNNER_ENV", "author-dev")
+
+	cfg, err := config.Load(workshop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		command, key string
+		want         string
+		source       string
+	}{
+		{"launch-server", "host-port", "2100", projectPath},
+		{"build-image", "host-port", "2000", projectPath},
+		{"launch-server", "mount-toml", "true", userPath},
+		{"build-image", "env", "author-dev", "env FORTIHUGORUNNER_ENV"},
+	}
+	for _, tt := range tests {
+		values, source, ok := cfg.Lookup(tt.command, tt.key)
+		if !ok || !slices.Equal(values, []string{tt.want}) || source != tt.source {
+			t.Errorf("Lookup(%s, %s): expected %s from %s, got %v from %q (%v)", tt.command, tt.key, tt.want, tt.source, values, source, ok)
+		}
+	}
+	if _, _, ok := cfg.Lookup("launch-server", "watch-dir"); ok {
+		t.Error("expected watch-dir to be unset")
+	}
+}
+
+func TestConfigWatchDirRelativeToFile(t *testing.T) {
+	_, root, workshop := configDirs(t)
+	writeConfig(t, filepath.Join(root, config.ProjectFileName), "launch-server:\n  watch-dir: site\n")
+
+	cfg, err := config.Load(workshop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, _, _ := cfg.Lookup("launch-server", "watch-dir")
+	if want := filepath.Join(root, "site"); !slices.Equal(values, []string{want}) {
+		t.Errorf("expected watch-dir resolved against the config file to %s, got %v", want, values)
+	}
+}
+
+// launchFlags are a subset of launch-server's flags.
+func launchFlags() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("launch-server", pflag.ContinueOnError)
+	flags.Int("host-port", 1313, "")
+	flags.String("watch-dir", ".", "")
+	flags.Bool("mount-toml", false, "")
+	return flags
+}
+
+func TestConfigApply(t *testing.T) {
+	_, root, workshop := configDirs(t)
+	writeConfig(t, filepath.Join(root, config.ProjectFileName), "host-port: 2000\nwatch-dir: site\n")
+	t.Setenv("FORTIHUGORUNNER_MOUNT_TOML", "true")
+
+	cfg, err := config.Load(workshop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := launchFlags()
+	if err := flags.Parse([]string{"--host-port=4000"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply("launch-server", flags); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	for name, want := range map[string]string{
+		"host-port":  "4000",
+		"watch-dir":  filepath.Join(root, "site"),
+		"mount-toml": "true",
+	} {
+		if got := flags.Lookup(name).Value.String(); got != want {
+			t.Errorf("expected %s=%s, got %s", name, want, got)
+		}
+	}
+
+	sources := map[string]string{}
+	for _, rf := range cfg.ResolveFlags("launch-server", flags) {
+		sources[rf.Name] = rf.Source
+	}
+	if sources["host-port"] != config.SourceFlag || sources["mount-toml"] != "env FORTIHUGORUNNER_MOUNT_TOML" {
+		t.Errorf("unexpected sources %v", sources)
+	}
+}
+
+func TestConfigApplyInvalidValue(t *testing.T) {
+	_, root, workshop := configDirs(t)
+	writeConfig(t, filepath.Join(root, config.ProjectFileName), "host-port: lots\n")
+
+	cfg, err := config.Load(workshop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply("launch-server", launchFlags()); err == nil || !strings.Contains(err.Error(), "host-port") {
+		t.Errorf("expected an invalid host-port error, got %v", err)
+	}
+}
+
+func TestConfigUnknownKeys(t *testing.T) {
+	_, root, workshop := configDirs(t)
+	writeConfig(t, filepath.Join(root, config.ProjectFileName), "host-prot: 2000\nmount-toml: true\nlaunch-server:\n  env: admin-dev\nbuild-imag:\n  env: admin-dev\n")
+
+	cfg, err := config.Load(workshop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	build := pflag.NewFlagSet("build-image", pflag.ContinueOnError)
+	build.String("env", "author-dev", "")
+	unknown := cfg.UnknownKeys(map[string]*pflag.FlagSet{"launch-server": launchFlags(), "build-image": build})
+
+	var got []string
+	for _, u := range unknown {
+		_, msg, _ := strings.Cut(u, ": ")
+		got = append(got, msg)
+	}
+	want := []string{`unknown key "host-prot"`, `unknown command "build-imag"`, `unknown key "env" for launch-server`}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, unknown)
+	}
+}
@@ -0,0 +1,178 @@
+package dockerinternal_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+	"fortihugorunner/dockerinternal/dockerfake"
+	"github.com/moby/moby/api/types/registry"
+)
+
+// stubHelper is a docker-credential-stub script. It answers for
+// registry.example.com and for the Docker Hub key, stores an identity token
+// for token.example.com, fails for broken.example.com and reports anything
+// else as not found.
+const stubHelper = `#!/bin/sh
+[ "$1" = get ] || exit 1
+read server
+case "$server" in
+registry.example.com|https://index.docker.io/v1/|127.0.0.1:*)
+	echo "{\"ServerURL\":\"$server\",\"Username\":\"alice\",\"Secret\":\"secret\"}" ;;
+token.example.com)
+	echo "{\"ServerURL\":\"$server\",\"Username\":\"<token>\",\"Secret\":\"refresh-token\"}" ;;
+broken.example.com)
+	echo "keychain is locked" >&2; exit 1 ;;
+*)
+	echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+
+// writeDockerConfig points DOCKER_CONFIG at a temporary config.json with the
+// given content and puts the stub credential helper on PATH.
+func writeDockerConfig(t *testing.T, config string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper stub is a shell script")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, "docker-credential-stub"), []byte(stubHelper), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func basicAuth(user, pass string) string {
+	return base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+}
+
+func TestCredentialStoreLookup(t *testing.T) {
+	config := fmt.Sprintf(`{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": %q},
+    "https://ghcr.io": {"auth": %q},
+    "quay.io": {"identitytoken": "quay-token"}
+  },
+  "credHelpers": {"registry.example.com": "stub", "token.example.com": "stub", "broken.example.com": "stub"}
+}`, basicAuth("hubuser", "hubpass"), basicAuth("ghuser", "ghpass"))
+	writeDockerConfig(t, config)
+
+	store, err := dockerinternal.LoadCredentialStore()
+	if err != nil {
+		t.Fatalf("LoadCredentialStore: %v", err)
+	}
+	tests := []struct {
+		domain  string
+		want    registry.AuthConfig
+		wantErr bool
+	}{
+		{domain: "docker.io", want: registry.AuthConfig{Username: "hubuser", Password: "hubpass", ServerAddress: "https://index.docker.io/v1/"}},
+		{domain: "ghcr.io", want: registry.AuthConfig{Username: "ghuser", Password: "ghpass", ServerAddress: "ghcr.io"}},
+		{domain: "quay.io", want: registry.AuthConfig{IdentityToken: "quay-token", ServerAddress: "quay.io"}},
+		{domain: "registry.example.com", want: registry.AuthConfig{Username: "alice", Password: "secret", ServerAddress: "registry.example.com"}},
+		{domain: "token.example.com", want: registry.AuthConfig{IdentityToken: "refresh-token", ServerAddress: "token.example.com"}},
+		{domain: "public.ecr.aws", want: registry.AuthConfig{}},
+		{domain: "broken.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := store.Lookup(tt.domain)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "keychain is locked") {
+				t.Errorf("Lookup(%q): expected the helper's error, got %v", tt.domain, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Lookup(%q): unexpected error %v", tt.domain, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Lookup(%q): expected %+v, got %+v", tt.domain, tt.want, got)
+		}
+	}
+}
+
+func TestCredentialStoreCredsStore(t *testing.T) {
+	writeDockerConfig(t, `{"credsStore": "stub", "auths": {"ghcr.io": {}}}`)
+
+	store, err := dockerinternal.LoadCredentialStore()
+	if err != nil {
+		t.Fatalf("LoadCredentialStore: %v", err)
+	}
+	for domain, want := range map[string]string{"docker.io": "alice", "registry.example.com": "alice", "ghcr.io": ""} {
+		user, _, err := store.Credentials(domain)
+		if err != nil || user != want {
+			t.Errorf("Credentials(%q): expected user %q, got %q (err %v)", domain, want, user, err)
+		}
+	}
+}
+
+func TestLoadCredentialStoreMissingConfig(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	store, err := dockerinternal.LoadCredentialStore()
+	if err != nil {
+		t.Fatalf("LoadCredentialStore: %v", err)
+	}
+	if auth, err := store.RegistryAuth("fortinet-hugo:latest"); err != nil || auth != "" {
+		t.Errorf("expected anonymous access, got %q (err %v)", auth, err)
+	}
+}
+
+func TestPullUsesRegistryAuth(t *testing.T) {
+	writeDockerConfig(t, `{"credHelpers": {"registry.example.com": "stub"}}`)
+
+	fake := dockerfake.New()
+	for _, ref := range []string{"registry.example.com/team/fortinet-hugo:latest", "public.ecr.aws/k4n6m5h8/fortinet-hugo:latest"} {
+		if err := dockerinternal.PullAndTag(fake, ref, "fortinet-hugo"); err != nil {
+			t.Fatalf("PullAndTag(%s): %v", ref, err)
+		}
+	}
+
+	pulls := fake.Pulls()
+	if len(pulls) != 2 {
+		t.Fatalf("expected 2 pulls, got %+v", pulls)
+	}
+	decoded, err := base64.URLEncoding.DecodeString(pulls[0].Options.RegistryAuth)
+	if err != nil {
+		t.Fatalf("RegistryAuth is not base64url: %v", err)
+	}
+	var auth registry.AuthConfig
+	if err := json.Unmarshal(decoded, &auth); err != nil {
+		t.Fatalf("RegistryAuth is not an AuthConfig: %v", err)
+	}
+	if auth.Username != "alice" || auth.Password != "secret" || auth.ServerAddress != "registry.example.com" {
+		t.Errorf("unexpected RegistryAuth %+v", auth)
+	}
+	if pulls[1].Options.RegistryAuth != "" {
+		t.Errorf("expected an anonymous pull without credentials, got %q", pulls[1].Options.RegistryAuth)
+	}
+}
+
+func TestNewRegistryUsesDockerCredentials(t *testing.T) {
+	writeDockerConfig(t, `{"credsStore": "stub"}`)
+
+	host, testReg := startTestRegistry(t, &testRegistry{auth: "basic"})
+	reg := dockerinternal.NewRegistry(nil)
+	reg.HTTPClient = testReg.HTTPClient
+	ref, _ := dockerinternal.ParseImageRef(host + "/team/fortinet-hugo:latest")
+
+	digest, err := reg.ManifestDigest(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("ManifestDigest: %v", err)
+	}
+	if digest != testManifestDigest {
+		t.Errorf("expected digest %s, got %s", testManifestDigest, digest)
+	}
+}
@@ -0,0 +1,87 @@
+package dockerinternal_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fortihugorunner/dockerinternal"
+	"fortihugorunner/dockerinternal/dockerfake"
+)
+
+func TestDigestCache(t *testing.T) {
+	ref := "public.ecr.aws/k4n6m5h8/fortinet-hugo:latest"
+	manifest := &dockerinternal.RemoteManifest{Digest: testManifestDigest, MediaType: "application/vnd.oci.image.manifest.v1+json"}
+	path := filepath.Join(t.TempDir(), "state", "digests.json")
+
+	cache := &dockerinternal.DigestCache{Path: path, TTL: time.Hour}
+	if _, _, ok := cache.Get(ref); ok {
+		t.Fatal("expected no entry in a new cache")
+	}
+	if err := cache.Put(ref, manifest); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, checkedAt, ok := cache.Get(ref)
+	if !ok || got.Digest != manifest.Digest || time.Since(checkedAt) > time.Minute {
+		t.Fatalf("expected the stored manifest, got %+v at %v (ok %v)", got, checkedAt, ok)
+	}
+
+	tests := []struct {
+		name  string
+		cache *dockerinternal.DigestCache
+	}{
+		{"expired", &dockerinternal.DigestCache{Path: path, TTL: time.Nanosecond}},
+		{"refresh", &dockerinternal.DigestCache{Path: path, TTL: time.Hour, Refresh: true}},
+		{"disabled", &dockerinternal.DigestCache{Path: path}},
+		{"nil", nil},
+	}
+	for _, tt := range tests {
+		if _, _, ok := tt.cache.Get(ref); ok {
+			t.Errorf("%s: expected a cache miss", tt.name)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := cache.Get(ref); ok {
+		t.Error("expected a corrupt cache file to miss")
+	}
+	if err := cache.Put(ref, manifest); err != nil {
+		t.Fatalf("Put over a corrupt cache file: %v", err)
+	}
+	if _, _, ok := cache.Get(ref); !ok {
+		t.Error("expected a corrupt cache file to be replaced")
+	}
+}
+
+func TestLocalImageCheckUsesDigestCache(t *testing.T) {
+	testReg := &testRegistry{}
+	host, reg := startTestRegistry(t, testReg)
+	remote := host + "/team/fortinet-hugo:latest"
+	reg.Cache = &dockerinternal.DigestCache{Path: filepath.Join(t.TempDir(), "digests.json"), TTL: time.Hour}
+
+	fake := dockerfake.New()
+	fake.AddImage(dockerfake.Image{
+		Ref:         remote,
+		ID:          "sha256:local",
+		RepoDigests: []string{host + "/team/fortinet-hugo@" + testManifestDigest},
+	})
+
+	for i, refresh := range []bool{false, false, true} {
+		reg.Cache.Refresh = refresh
+		if err := dockerinternal.LocalImageCheck(fake, reg, remote, ""); err != nil {
+			t.Fatalf("check %d: LocalImageCheck: %v", i, err)
+		}
+	}
+	if testReg.manifestRequests != 2 {
+		t.Errorf("expected the registry to be asked on the first check and with Refresh only, got %d requests", testReg.manifestRequests)
+	}
+	for _, call := range fake.Calls() {
+		if strings.HasPrefix(call, "ImagePull") {
+			t.Errorf("expected no pull for an up-to-date image, got %v", fake.Calls())
+		}
+	}
+}
@@ -0,0 +1,691 @@
+package dockerinternal_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fortihugorunner/dockerinternal"
+	"fortihugorunner/dockerinternal/dockerfake"
+	"fortihugorunner/version"
+	"github.com/moby/moby/api/types/build"
+	"github.com/moby/moby/api/types/mount"
+)
+
+func TestStartContainer(t *testing.T) {
+	tests := []struct {
+		name       string
+		mountToml  bool
+		wantMounts []string
+	}{
+		{"workshop only", false, []string{"/home/UserRepo"}},
+		{"with hugo.toml", true, []string{"/home/UserRepo", "/home/CentralRepo/hugo.toml"}},
+	}
+	for _, tt := range tests {
+		fake := dockerfake.New()
+		cfg := testServerConfig(t)
+		cfg.MountToml = tt.mountToml
+
+		id, err := dockerinternal.StartContainer(context.Background(), fake, cfg)
+		if err != nil {
+			t.Fatalf("%s: StartContainer: %v", tt.name, err)
+		}
+		containers := fake.Containers()
+		if len(containers) != 1 || containers[0].ID != id {
+			t.Fatalf("%s: expected one container %s, got %+v", tt.name, id, containers)
+		}
+		c := containers[0]
+		if !c.Running {
+			t.Errorf("%s: container was not started", tt.name)
+		}
+		if c.Name != dockerinternal.ContainerName(cfg.WatchDir) {
+			t.Errorf("%s: expected name %q, got %q", tt.name, dockerinternal.ContainerName(cfg.WatchDir), c.Name)
+		}
+		if c.Config.Image != cfg.DockerImage {
+			t.Errorf("%s: expected image %q, got %q", tt.name, cfg.DockerImage, c.Config.Image)
+		}
+		if c.Config.Labels[dockerinternal.LabelWorkshop] != cfg.WatchDir || c.Config.Labels[dockerinternal.LabelPort] != cfg.HostPort {
+			t.Errorf("%s: unexpected labels %v", tt.name, c.Config.Labels)
+		}
+		var targets []string
+		for _, m := range c.HostConfig.Mounts {
+			if m.Type != mount.TypeBind {
+				t.Errorf("%s: expected bind mount, got %s", tt.name, m.Type)
+			}
+			targets = append(targets, m.Target)
+		}
+		if !slices.Equal(targets, tt.wantMounts) {
+			t.Errorf("%s: expected mounts %v, got %v", tt.name, tt.wantMounts, targets)
+		}
+		for port, bindings := range c.HostConfig.PortBindings {
+			if port.Port() != cfg.ContainerPort || len(bindings) != 1 ||
+				bindings[0].HostPort != cfg.HostPort || bindings[0].HostIP != cfg.BindAddress {
+				t.Errorf("%s: unexpected port binding %s -> %+v", tt.name, port, bindings)
+			}
+		}
+	}
+}
+
+func TestStartContainerHostPortFallback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	taken := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	tests := []struct {
+		name     string
+		hostPort string
+		fallback bool
+		sameCmd  bool
+	}{
+		{name: "same port", hostPort: "1313", sameCmd: true},
+		{name: "fallback", hostPort: taken, fallback: true},
+		{name: "auto", hostPort: dockerinternal.AutoPort},
+	}
+	for _, tt := range tests {
+		fake := dockerfake.New()
+		cfg := testServerConfig(t)
+		cfg.HostPort = tt.hostPort
+		cfg.ContainerPort = taken
+		if tt.sameCmd {
+			cfg.ContainerPort = tt.hostPort
+		}
+		if err := dockerinternal.ResolveHostPort(context.Background(), fake, &cfg, tt.fallback); err != nil {
+			t.Fatalf("%s: ResolveHostPort: %v", tt.name, err)
+		}
+		if _, err := dockerinternal.StartContainer(context.Background(), fake, cfg); err != nil {
+			t.Fatalf("%s: StartContainer: %v", tt.name, err)
+		}
+
+		want := []string{"server", "--bind", "0.0.0.0"}
+		if !tt.sameCmd {
+			if cfg.HostPort == taken {
+				t.Fatalf("%s: expected a port other than %s", tt.name, taken)
+			}
+			want = append(want, "--liveReloadPort", cfg.HostPort, "--baseURL", "http://localhost:"+cfg.HostPort+"/", "--appendPort=false")
+		}
+		if got := fake.Containers()[0].Config.Cmd; !slices.Equal(got, want) {
+			t.Errorf("%s: expected command %q, got %q", tt.name, want, got)
+		}
+	}
+}
+
+func TestStartContainerNameConflict(t *testing.T) {
+	fake := dockerfake.New()
+	cfg := testServerConfig(t)
+	fake.AddContainer(dockerfake.Container{ID: "other", Name: dockerinternal.ContainerName(cfg.WatchDir)})
+
+	if _, err := dockerinternal.StartContainer(context.Background(), fake, cfg); err == nil {
+		t.Fatal("expected an error when the container name is taken")
+	}
+}
+
+func TestStopAndRemoveContainer(t *testing.T) {
+	fake := dockerfake.New()
+	fake.AddContainer(dockerfake.Container{ID: "abc", Name: "running", Running: true})
+
+	dockerinternal.StopAndRemoveContainer(fake, "abc")
+	assertNoContainers(t, fake)
+	if calls := fake.Calls(); !slices.Equal(calls, []string{"ContainerStop abc", "ContainerRemove abc"}) {
+		t.Errorf("unexpected calls %v", calls)
+	}
+}
+
+func TestListSessions(t *testing.T) {
+	fake := dockerfake.New()
+	cfg := testServerConfig(t)
+	if _, err := dockerinternal.StartContainer(context.Background(), fake, cfg); err != nil {
+		t.Fatalf("StartContainer: %v", err)
+	}
+	fake.AddContainer(dockerfake.Container{ID: "unrelated", Name: "postgres", Running: true})
+
+	sessions, err := dockerinternal.ListSessions(context.Background(), fake, false)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("expected one runner session, got %+v", sessions)
+	}
+	s := sessions[0]
+	if s.Workshop != cfg.WatchDir || s.HostPort != cfg.HostPort || s.BindAddress != cfg.BindAddress || !s.Running() {
+		t.Errorf("unexpected session %+v", s)
+	}
+}
+
+func TestRebuildInContainer(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		wantErr  bool
+	}{
+		{0, false},
+		{1, true},
+	}
+	for _, tt := range tests {
+		fake := dockerfake.New()
+		fake.AddContainer(dockerfake.Container{ID: "abc", Running: true})
+		fake.ExecExitCode = tt.exitCode
+
+		err := dockerinternal.RebuildInContainer(context.Background(), fake, "abc")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("exit code %d: expected error %v, got %v", tt.exitCode, tt.wantErr, err)
+		}
+		if calls := fake.Calls(); len(calls) == 0 || calls[0] != "ExecCreate abc hugo" {
+			t.Errorf("exit code %d: expected hugo to be exec'd, got calls %v", tt.exitCode, calls)
+		}
+	}
+}
+
+func TestPullAndTag(t *testing.T) {
+	const ref = "public.ecr.aws/k4n6m5h8/fortinet-hugo:latest"
+
+	fake := dockerfake.New()
+	if err := dockerinternal.PullAndTag(fake, ref, "fortinet-hugo"); err != nil {
+		t.Fatalf("PullAndTag: %v", err)
+	}
+	want := []string{"ImagePull " + ref, "ImageTag " + ref + " fortinet-hugo"}
+	if calls := fake.Calls(); !slices.Equal(calls, want) {
+		t.Errorf("expected calls %v, got %v", want, calls)
+	}
+	var refs []string
+	for _, img := range fake.Images() {
+		refs = append(refs, img.Ref)
+	}
+	if !slices.Contains(refs, "fortinet-hugo") {
+		t.Errorf("expected a fortinet-hugo tag, got %v", refs)
+	}
+
+	failing := dockerfake.New()
+	failing.PullErrors = map[string]error{ref: errors.New("denied")}
+	if err := dockerinternal.PullAndTag(failing, ref, "fortinet-hugo"); err == nil {
+		t.Fatal("expected the pull error to be returned")
+	}
+	if calls := failing.Calls(); slices.ContainsFunc(calls, func(c string) bool { return strings.HasPrefix(c, "ImageTag") }) {
+		t.Errorf("expected no tag after a failed pull, got calls %v", calls)
+	}
+}
+
+const testDockerfile = `FROM docker.io/hugomods/hugo:std AS base
+
+FROM base as prod
+ADD https://github.com/FortinetCloudCSE/CentralRepo.git#prreviewJune23 /home/CentralRepo
+
+FROM base as dev
+ADD https://github.com/FortinetCloudCSE/CentralRepo.git#main /home/CentralRepo
+`
+
+var testBuildConfig = dockerinternal.BuildConfig{ImageName: "fortinet-hugo", Target: "prod", HugoVersion: "std"}
+
+// chdirBuildContext changes to a workshop directory with testDockerfile.
+func chdirBuildContext(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(testDockerfile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "hugo.toml"), []byte("title = 'x'\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	// Keep the build cache state file out of the real cache directory.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+}
+
+func TestBuildDockerImage(t *testing.T) {
+	chdirBuildContext(t)
+
+	fake := dockerfake.New()
+	if err := dockerinternal.BuildDockerImage(fake, testBuildConfig); err != nil {
+		t.Fatalf("BuildDockerImage: %v", err)
+	}
+
+	calls := fake.Calls()
+	for _, want := range []string{"ImagePull docker/dockerfile:1.5-labs", "ImagePull docker.io/hugomods/hugo:std"} {
+		if !slices.Contains(calls, want) {
+			t.Errorf("expected call %q, got %v", want, calls)
+		}
+	}
+	builds := fake.Builds()
+	if len(builds) != 1 {
+		t.Fatalf("expected one build, got %d", len(builds))
+	}
+	b := builds[0]
+	if b.Options.Target != "prod" || !slices.Equal(b.Options.Tags, []string{"fortinet-hugo"}) {
+		t.Errorf("unexpected build options: target %q, tags %v", b.Options.Target, b.Options.Tags)
+	}
+	if b.Options.Labels[dockerinternal.LabelVersion] != version.Version {
+		t.Errorf("expected the %s label, got %v", dockerinternal.LabelVersion, b.Options.Labels)
+	}
+	for _, want := range []string{"Dockerfile", "hugo.toml"} {
+		if !slices.Contains(b.Files, want) {
+			t.Errorf("expected %s in the build context, got %v", want, b.Files)
+		}
+	}
+}
+
+func TestBuildDockerImageFailedStep(t *testing.T) {
+	chdirBuildContext(t)
+
+	fake := dockerfake.New()
+	fake.BuildOutput = `{"stream":"Step 1/2 : FROM docker.io/hugomods/hugo:std\n"}
+{"stream":"Step 2/2 : RUN hugo\n"}
+{"errorDetail":{"code":1,"message":"The command '/bin/sh -c hugo' returned a non-zero code: 1"},"error":"The command '/bin/sh -c hugo' returned a non-zero code: 1"}
+`
+	err := dockerinternal.BuildDockerImage(fake, testBuildConfig)
+	var streamErr *dockerinternal.StreamError
+	if !errors.As(err, &streamErr) || streamErr.Code != 1 || !strings.Contains(err.Error(), "returned a non-zero code") {
+		t.Fatalf("expected the failed step as a StreamError, got %v", err)
+	}
+	var buildErr *dockerinternal.BuildError
+	if !errors.As(err, &buildErr) || buildErr.Step != "RUN hugo" {
+		t.Errorf("expected a BuildError for RUN hugo, got %v", err)
+	}
+}
+
+func TestBuildDockerImageBaseImagePullFails(t *testing.T) {
+	chdirBuildContext(t)
+
+	fake := dockerfake.New()
+	fake.PullErrors = map[string]error{"docker.io/hugomods/hugo:std": errors.New("manifest unknown")}
+	err := dockerinternal.BuildDockerImage(fake, testBuildConfig)
+	if err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Fatalf("expected the pull error, got %v", err)
+	}
+	if len(fake.Builds()) != 0 {
+		t.Error("expected no build after a failed base image pull")
+	}
+}
+
+func TestPullAndTagStreamError(t *testing.T) {
+	fake := dockerfake.New()
+	fake.PullOutput = `{"status":"Pulling from team/fortinet-hugo","id":"latest"}
+{"errorDetail":{"message":"toomanyrequests: rate limit exceeded"},"error":"toomanyrequests: rate limit exceeded"}
+`
+	err := dockerinternal.PullAndTag(fake, "docker.io/team/fortinet-hugo:latest", "fortinet-hugo")
+	if err == nil || !strings.Contains(err.Error(), "toomanyrequests") {
+		t.Fatalf("expected the stream error, got %v", err)
+	}
+	if slices.Contains(fake.Calls(), "ImageTag docker.io/team/fortinet-hugo:latest fortinet-hugo") {
+		t.Error("expected no tag after a failed pull")
+	}
+}
+
+func TestBuildDockerImageMissingStage(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte("FROM scratch\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	fake := dockerfake.New()
+	if err := dockerinternal.BuildDockerImage(fake, testBuildConfig); err == nil {
+		t.Fatal("expected an error for a Dockerfile without the target stage")
+	}
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("expected no Docker calls, got %v", calls)
+	}
+}
+
+func TestBuildDockerImageUnparsedDockerfile(t *testing.T) {
+	chdirBuildContext(t)
+	// An instruction the parser rejects must not stop the build.
+	if err := os.WriteFile("Dockerfile", []byte("FROM a b c d\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := dockerfake.New()
+	if err := dockerinternal.BuildDockerImage(fake, testBuildConfig); err != nil {
+		t.Fatalf("BuildDockerImage: %v", err)
+	}
+	if len(fake.Builds()) != 1 {
+		t.Errorf("expected one build, got %d", len(fake.Builds()))
+	}
+}
+
+func TestWatchAndRestartRestartsOnChange(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the restart debounce")
+	}
+	fake := dockerfake.New()
+	cfg := testServerConfig(t)
+	session := dockerinternal.NewServerSession(fake, cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := session.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	first := session.ContainerID()
+	done := make(chan struct{})
+	go func() {
+		dockerinternal.WatchAndRestart(ctx, session)
+		close(done)
+	}()
+
+	// Give the poller its initial snapshot before changing a file.
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(cfg.WatchDir, "_index.md"), []byte("# changed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for session.ContainerID() == first || session.ContainerID() == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("container was not restarted; calls: %v", fake.Calls())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+	session.Shutdown()
+	assertNoContainers(t, fake)
+}
+
+func TestBuildDockerImageKeepsOtherBuildCache(t *testing.T) {
+	chdirBuildContext(t)
+
+	ctx := context.Background()
+	fake := dockerfake.New()
+	foreign := build.CacheRecord{ID: "other-project", Size: 100}
+	ours := build.CacheRecord{ID: "runner-layer", Size: 200}
+
+	// A previous build-image run created runner-layer.
+	fake.SetBuildCache([]build.CacheRecord{foreign})
+	before, err := dockerinternal.BuildCacheIDs(ctx, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.SetBuildCache([]build.CacheRecord{foreign, ours})
+	if err := dockerinternal.RecordBuildCache(ctx, fake, before); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dockerinternal.BuildDockerImage(fake, testBuildConfig); err != nil {
+		t.Fatalf("BuildDockerImage: %v", err)
+	}
+	for _, call := range fake.Calls() {
+		if strings.HasPrefix(call, "BuildCachePrune") {
+			t.Fatalf("expected no cache pruning without PruneCache, got %v", fake.Calls())
+		}
+	}
+
+	cfg := testBuildConfig
+	cfg.PruneCache = true
+	if err := dockerinternal.BuildDockerImage(fake, cfg); err != nil {
+		t.Fatalf("BuildDockerImage with PruneCache: %v", err)
+	}
+	if !slices.Contains(fake.Calls(), "BuildCachePrune runner-layer") {
+		t.Errorf("expected the runner's cache to be pruned by ID, got %v", fake.Calls())
+	}
+	usage, err := dockerinternal.BuildCacheIDs(ctx, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !usage["other-project"] || usage["runner-layer"] {
+		t.Errorf("expected only other-project's cache to remain, got %v", usage)
+	}
+}
+
+func TestBuildDockerImageCacheOptions(t *testing.T) {
+	chdirBuildContext(t)
+
+	fake := dockerfake.New()
+	cfg := testBuildConfig
+	cfg.NoCache = true
+	cfg.CacheFrom = []string{"ghcr.io/my-team/fortinet-hugo:cache"}
+	if err := dockerinternal.BuildDockerImage(fake, cfg); err != nil {
+		t.Fatalf("BuildDockerImage: %v", err)
+	}
+	builds := fake.Builds()
+	if len(builds) != 1 {
+		t.Fatalf("expected one API build, got %d", len(builds))
+	}
+	if !builds[0].Options.NoCache || !slices.Equal(builds[0].Options.CacheFrom, cfg.CacheFrom) {
+		t.Errorf("expected NoCache and CacheFrom to be passed, got %+v", builds[0].Options)
+	}
+}
+
+func TestBuildDockerImageBuildx(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("docker stub is a shell script")
+	}
+	chdirBuildContext(t)
+	argsFile := stubDocker(t, "docker-container", "")
+
+	fake := dockerfake.New()
+	cfg := testBuildConfig
+	cfg.CacheFrom = []string{"type=local,src=.buildcache"}
+	cfg.CacheTo = []string{"type=local,dest=.buildcache,mode=max"}
+	if !dockerinternal.NeedsBuildx(cfg) {
+		t.Fatal("expected --cache-to to need buildx")
+	}
+	if err := dockerinternal.BuildDockerImage(fake, cfg); err != nil {
+		t.Fatalf("BuildDockerImage: %v", err)
+	}
+	if len(fake.Builds()) != 0 {
+		t.Errorf("expected no API build, got %d", len(fake.Builds()))
+	}
+
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatalf("docker was not run: %v", err)
+	}
+	got := strings.Split(strings.TrimSpace(string(data)), "\n")
+	want := []string{
+		"buildx", "build", "--progress=plain", "--load", "--file", "Dockerfile",
+		"--target", "prod", "--tag", "fortinet-hugo",
+		"--build-arg", "BUILDKIT_INLINE_CACHE=1", "--build-arg", "HUGO_VERSION=std",
+		"--label", dockerinternal.LabelHugoVersion + "=std",
+		"--label", dockerinternal.LabelVersion + "=" + version.Version,
+		"--cache-from", "type=local,src=.buildcache",
+		"--cache-to", "type=local,dest=.buildcache,mode=max",
+		".",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("unexpected docker arguments:\n got %q\nwant %q", got, want)
+	}
+
+	if dockerinternal.NeedsBuildx(dockerinternal.BuildConfig{CacheFrom: []string{"fortinet-hugo:latest"}}) {
+		t.Error("expected image cache sources to use the API build")
+	}
+}
+
+// stubDocker stands in for the docker CLI. buildx inspect reports driver;
+// other commands record their arguments in the returned file and then run
+// build, e.g. to fail the build.
+func stubDocker(t *testing.T, driver string, build string) string {
+	binDir := t.TempDir()
+	argsFile := filepath.Join(binDir, "args")
+	stub := "#!/bin/sh\n" +
+		"if [ \"$1 $2\" = \"buildx inspect\" ]; then printf 'Name: default\\nDriver: " + driver + "\\n'; exit 0; fi\n" +
+		"printf '%s\\n' \"$@\" > " + argsFile + "\n" + build
+	if err := os.WriteFile(filepath.Join(binDir, "docker"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestBuildDockerImageBuildxDockerDriver(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("docker stub is a shell script")
+	}
+	chdirBuildContext(t)
+	argsFile := stubDocker(t, "docker", "")
+
+	cfg := testBuildConfig
+	cfg.CacheTo = []string{"type=local,dest=.buildcache"}
+	err := dockerinternal.BuildDockerImage(dockerfake.New(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "docker-container driver") {
+		t.Fatalf("expected a docker driver error, got %v", err)
+	}
+	if _, err := os.Stat(argsFile); err == nil {
+		t.Error("expected no buildx build with the docker driver")
+	}
+
+	// The docker driver exports inline cache.
+	cfg.CacheTo = []string{"type=inline"}
+	if err := dockerinternal.BuildDockerImage(dockerfake.New(), cfg); err != nil {
+		t.Errorf("expected inline cache export to build, got %v", err)
+	}
+}
+
+func TestBuildDockerImageBuildxFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("docker stub is a shell script")
+	}
+	chdirBuildContext(t)
+	stubDocker(t, "docker-container", "echo '#5 [prod 2/2] RUN hugo'\necho 'Error: failed to render pages' >&2\nprintf 'ERROR: failed to solve: exit code: 1'\nexit 1\n")
+
+	cfg := testBuildConfig
+	cfg.CacheTo = []string{"type=local,dest=.buildcache"}
+	err := dockerinternal.BuildDockerImage(dockerfake.New(), cfg)
+	var buildErr *dockerinternal.BuildError
+	if !errors.As(err, &buildErr) {
+		t.Fatalf("expected a BuildError, got %v", err)
+	}
+	if !slices.Contains(buildErr.Log, "Error: failed to render pages") || buildErr.Log[len(buildErr.Log)-1] != "ERROR: failed to solve: exit code: 1" {
+		t.Errorf("expected the buildx output tail in the BuildError, got %q", buildErr.Log)
+	}
+}
+
+func TestBuildDockerImageCustomOptions(t *testing.T) {
+	chdirBuildContext(t)
+	dockerfile := testDockerfile + "\nFROM base as ci\nADD https://github.com/FortinetCloudCSE/CentralRepo.git#main /home/CentralRepo\n"
+	if err := os.MkdirAll("docker", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("docker", "Dockerfile.ci"), []byte(dockerfile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("THEME_BRANCH", "feature-x")
+
+	fake := dockerfake.New()
+	str := func(s string) *string { return &s }
+	cfg := dockerinternal.BuildConfig{
+		ImageName:   "fortinet-hugo:ci",
+		Tags:        []string{"registry.example.com/fortinet-hugo:ci"},
+		Target:      "ci",
+		HugoVersion: "std",
+		Dockerfile:  filepath.Join("docker", "Dockerfile.ci"),
+		BuildArgs:   map[string]*string{"HUGO_ENV": str("production"), "THEME_BRANCH": nil, "UNSET_VAR": nil},
+		Labels:      map[string]string{"team": "cse", dockerinternal.LabelVersion: "spoofed"},
+	}
+	if err := dockerinternal.BuildDockerImage(fake, cfg); err != nil {
+		t.Fatalf("BuildDockerImage: %v", err)
+	}
+
+	builds := fake.Builds()
+	if len(builds) != 1 {
+		t.Fatalf("expected one build, got %d", len(builds))
+	}
+	opts := builds[0].Options
+	if opts.Target != "ci" || opts.Dockerfile != "docker/Dockerfile.ci" {
+		t.Errorf("expected target ci from docker/Dockerfile.ci, got %q from %q", opts.Target, opts.Dockerfile)
+	}
+	if want := []string{"fortinet-hugo:ci", "registry.example.com/fortinet-hugo:ci"}; !slices.Equal(opts.Tags, want) {
+		t.Errorf("expected tags %v, got %v", want, opts.Tags)
+	}
+	for key, want := range map[string]string{"HUGO_ENV": "production", "THEME_BRANCH": "feature-x", "BUILDKIT_INLINE_CACHE": "1"} {
+		if v := opts.BuildArgs[key]; v == nil || *v != want {
+			t.Errorf("expected build arg %s=%s, got %v", key, want, v)
+		}
+	}
+	if _, ok := opts.BuildArgs["UNSET_VAR"]; ok {
+		t.Error("expected a build arg missing from the environment to be left out")
+	}
+	if opts.Labels["team"] != "cse" || opts.Labels[dockerinternal.LabelVersion] != version.Version {
+		t.Errorf("expected the team label and the runner's version label, got %v", opts.Labels)
+	}
+	if !slices.Contains(builds[0].Files, "docker/Dockerfile.ci") {
+		t.Errorf("expected the Dockerfile in the build context, got %v", builds[0].Files)
+	}
+}
+
+// hugoArgDockerfile follows --hugo-version through the HUGO_VERSION arg.
+const hugoArgDockerfile = `ARG HUGO_VERSION=std
+FROM docker.io/hugomods/hugo:${HUGO_VERSION} AS base
+
+FROM base as prod
+ADD https://github.com/FortinetCloudCSE/CentralRepo.git#main /home/CentralRepo
+`
+
+func TestBuildDockerImageHugoVersion(t *testing.T) {
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name       string
+		dockerfile string
+		cfg        dockerinternal.BuildConfig
+		pull       string
+		arg        string
+		label      string
+	}{
+		{
+			name:       "build arg",
+			dockerfile: hugoArgDockerfile,
+			cfg:        dockerinternal.BuildConfig{ImageName: "fortinet-hugo", Target: "prod", HugoVersion: "0.146.0"},
+			pull:       "docker.io/hugomods/hugo:0.146.0",
+			arg:        "0.146.0",
+			label:      "0.146.0",
+		},
+		{
+			name:       "explicit --build-arg wins",
+			dockerfile: hugoArgDockerfile,
+			cfg: dockerinternal.BuildConfig{ImageName: "fortinet-hugo", Target: "prod", HugoVersion: "std",
+				BuildArgs: map[string]*string{"HUGO_VERSION": str("exts")}},
+			pull:  "docker.io/hugomods/hugo:exts",
+			arg:   "exts",
+			label: "exts",
+		},
+		{
+			name:       "Dockerfile default",
+			dockerfile: strings.Replace(hugoArgDockerfile, "HUGO_VERSION=std", "HUGO_VERSION=0.146.0", 1),
+			cfg:        dockerinternal.BuildConfig{ImageName: "fortinet-hugo", Target: "prod"},
+			pull:       "docker.io/hugomods/hugo:0.146.0",
+			label:      "0.146.0",
+		},
+		{
+			name:       "pinned tag",
+			dockerfile: testDockerfile,
+			cfg:        dockerinternal.BuildConfig{ImageName: "fortinet-hugo", Target: "prod", HugoVersion: "0.146.0"},
+			pull:       "docker.io/hugomods/hugo:std",
+			arg:        "0.146.0",
+			label:      "std",
+		},
+	}
+	for _, tt := range tests {
+		chdirBuildContext(t)
+		if err := os.WriteFile("Dockerfile", []byte(tt.dockerfile), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		fake := dockerfake.New()
+		if err := dockerinternal.BuildDockerImage(fake, tt.cfg); err != nil {
+			t.Errorf("%s: BuildDockerImage: %v", tt.name, err)
+			continue
+		}
+		if calls := fake.Calls(); !slices.Contains(calls, "ImagePull "+tt.pull) {
+			t.Errorf("%s: expected %s to be pulled, got %v", tt.name, tt.pull, calls)
+		}
+		opts := fake.Builds()[0].Options
+		v, ok := opts.BuildArgs[dockerinternal.HugoVersionArg]
+		switch {
+		case tt.arg == "" && ok:
+			t.Errorf("%s: expected no HUGO_VERSION build arg, got %q", tt.name, *v)
+		case tt.arg != "" && (v == nil || *v != tt.arg):
+			t.Errorf("%s: expected HUGO_VERSION=%s, got %v", tt.name, tt.arg, v)
+		}
+		if got := opts.Labels[dockerinternal.LabelHugoVersion]; got != tt.label {
+			t.Errorf("%s: expected the %s label %q, got %q", tt.name, dockerinternal.LabelHugoVersion, tt.label, got)
+		}
+	}
+}
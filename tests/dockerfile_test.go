@@ -0,0 +1,143 @@
+package dockerinternal_test
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+)
+
+// dockerfileFixtures describes each Dockerfile in testdata/dockerfiles: its
+// stages and the CentralRepo branch and source lines of some targets.
+var dockerfileFixtures = map[string]struct {
+	stages  []string
+	targets []fixtureTarget
+}{
+	"classic.Dockerfile": {
+		stages: []string{"base", "prod", "dev"},
+		targets: []fixtureTarget{
+			{target: "prod", branch: "prreviewJune23", lines: []int{5}},
+			{target: "dev", branch: "main", lines: []int{8}},
+			{target: "base"},
+		},
+	},
+	"case_whitespace.Dockerfile": {
+		stages:  []string{"base", "prod"},
+		targets: []fixtureTarget{{target: "PROD", branch: "release/2025", lines: []int{4}}},
+	},
+	"continuations.Dockerfile": {
+		stages:  []string{"base", "prod"},
+		targets: []fixtureTarget{{target: "prod", branch: "v2.1", lines: []int{5}}},
+	},
+	"arg_expansion.Dockerfile": {
+		stages: []string{"base", "prod", "dev"},
+		targets: []fixtureTarget{
+			{target: "prod", branch: "main", lines: []int{9}},
+			{target: "prod", buildArgs: map[string]string{"CENTRAL_BRANCH": "feature-x"}, branch: "feature-x", lines: []int{9}},
+			{target: "dev", branch: "develop", lines: []int{14}},
+			{target: "dev", buildArgs: map[string]string{"CENTRAL_BRANCH": ""}, branch: "main", lines: []int{14}},
+		},
+	},
+	"git_clone.Dockerfile": {
+		stages: []string{"base", "prod", "dev", "ci"},
+		targets: []fixtureTarget{
+			{target: "prod", branch: "prreview", lines: []int{5}},
+			{target: "dev", branch: "feature/search", lines: []int{9, 9}},
+			{target: "ci", branch: "ci-fixes", lines: []int{14}},
+		},
+	},
+	"escape_backtick.Dockerfile": {
+		stages:  []string{"base", "prod"},
+		targets: []fixtureTarget{{target: "prod", branch: "windows", lines: []int{5}}},
+	},
+	"inherited.Dockerfile": {
+		stages: []string{"base", "central", "prod", "3"},
+		targets: []fixtureTarget{
+			{target: "prod", branch: "main", lines: []int{4}},
+			{target: "", branch: "nightly", lines: []int{4, 10}},
+		},
+	},
+	"heredoc.Dockerfile": {
+		stages: []string{"base", "prod"},
+		targets: []fixtureTarget{
+			// A theme fetched under another name is not CentralRepo.
+			{target: "base", branch: "", lines: []int{7}},
+			{target: "prod", branch: "heredoc", lines: []int{7, 16}},
+		},
+	},
+	"other_sources.Dockerfile": {
+		stages:  []string{"prod"},
+		targets: []fixtureTarget{{target: "prod", branch: "", lines: []int{2, 4}}},
+	},
+}
+
+type fixtureTarget struct {
+	target    string
+	buildArgs map[string]string
+	branch    string
+	lines     []int
+}
+
+func TestDockerfileFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "dockerfiles", "*.Dockerfile"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no Dockerfile fixtures found: %v", err)
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		want, ok := dockerfileFixtures[name]
+		if !ok {
+			t.Errorf("%s: fixture has no expectations", name)
+			continue
+		}
+		df, err := dockerinternal.LoadDockerfile(file)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		var stages []string
+		for _, stage := range df.Stages {
+			stages = append(stages, stage.ID())
+		}
+		if !slices.Equal(stages, want.stages) {
+			t.Errorf("%s: expected stages %v, got %v", name, want.stages, stages)
+		}
+		for _, tt := range want.targets {
+			sources, err := df.Sources(tt.target, tt.buildArgs)
+			if err != nil {
+				t.Errorf("%s %q: Sources: %v", name, tt.target, err)
+				continue
+			}
+			var lines []int
+			for _, src := range sources {
+				lines = append(lines, src.Line)
+			}
+			if !slices.Equal(lines, tt.lines) {
+				t.Errorf("%s %q: expected sources on lines %v, got %+v", name, tt.target, tt.lines, sources)
+			}
+			if branch := dockerinternal.CentralRepoBranch(sources); branch != tt.branch {
+				t.Errorf("%s %q: expected CentralRepo branch %q, got %q", name, tt.target, tt.branch, branch)
+			}
+		}
+	}
+}
+
+func TestDockerfileSourcesMissingStage(t *testing.T) {
+	df, err := dockerinternal.ParseDockerfile("FROM scratch\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := df.Sources("prod", nil); err == nil || !strings.Contains(err.Error(), `stage "prod" not found`) {
+		t.Errorf("expected a missing stage error, got %v", err)
+	}
+}
+
+func TestParseDockerfileErrors(t *testing.T) {
+	for _, content := range []string{"", "# only a comment\n", "RUN echo\nFROM scratch\n", "FROM\n", "FROM a AS\n", "# escape=x\nFROM scratch\n"} {
+		if _, err := dockerinternal.ParseDockerfile(content); err == nil {
+			t.Errorf("ParseDockerfile(%q): expected an error", content)
+		}
+	}
+}
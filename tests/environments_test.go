@@ -0,0 +1,72 @@
+package dockerinternal_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+)
+
+func TestLookupEnvironment(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		image  string
+	}{
+		{"author-dev", "prod", "fortinet-hugo"},
+		{"admin-dev", "dev", "hugotester"},
+	}
+	for _, tt := range tests {
+		env, err := dockerinternal.LookupEnvironment(tt.name)
+		if err != nil {
+			t.Errorf("LookupEnvironment(%q): unexpected error %v", tt.name, err)
+			continue
+		}
+		if env.Target != tt.target || env.Image != tt.image {
+			t.Errorf("LookupEnvironment(%q): expected %s → %s, got %+v", tt.name, tt.target, tt.image, env)
+		}
+	}
+
+	_, err := dockerinternal.LookupEnvironment("staging")
+	if err == nil || !strings.Contains(err.Error(), "author-dev, admin-dev") {
+		t.Errorf("expected an error listing the environments, got %v", err)
+	}
+	if dockerinternal.DefaultEnvironment != "author-dev" {
+		t.Errorf("expected author-dev as the default, got %q", dockerinternal.DefaultEnvironment)
+	}
+	if dockerinternal.DefaultImage != "fortinet-hugo:latest" {
+		t.Errorf("expected fortinet-hugo:latest as the default image, got %q", dockerinternal.DefaultImage)
+	}
+}
+
+func TestRunnerImageNamesFollowEnvironments(t *testing.T) {
+	for _, env := range dockerinternal.Environments {
+		if !slices.Contains(dockerinternal.RunnerImageNames, env.Image) {
+			t.Errorf("expected %s in RunnerImageNames %v", env.Image, dockerinternal.RunnerImageNames)
+		}
+		if !strings.Contains(dockerinternal.EnvironmentUsage(), env.Name+" ("+env.Target+" stage) → "+env.Image+" image, "+env.Description) {
+			t.Errorf("expected %s in the usage text %q", env.Name, dockerinternal.EnvironmentUsage())
+		}
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	got, err := dockerinternal.ParseKeyValues("build-arg", []string{"HUGO_ENV=production", "EMPTY=", "FROM_ENV", "URL=https://example.com/?a=b"})
+	if err != nil {
+		t.Fatalf("ParseKeyValues: %v", err)
+	}
+	want := map[string]string{"HUGO_ENV": "production", "EMPTY": "", "URL": "https://example.com/?a=b"}
+	for key, value := range want {
+		if got[key] == nil || *got[key] != value {
+			t.Errorf("expected %s=%q, got %v", key, value, got[key])
+		}
+	}
+	if v, ok := got["FROM_ENV"]; !ok || v != nil {
+		t.Errorf("expected FROM_ENV without a value, got %v (present %v)", v, ok)
+	}
+
+	if _, err := dockerinternal.ParseKeyValues("label", []string{"=value"}); err == nil || !strings.Contains(err.Error(), "--label") {
+		t.Errorf("expected an error naming --label, got %v", err)
+	}
+}
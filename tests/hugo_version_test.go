@@ -0,0 +1,98 @@
+package dockerinternal_test
+
+import (
+	"strings"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+)
+
+func TestDockerfileHugoBase(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		target     string
+		buildArgs  map[string]string
+		image      string
+		usesArg    bool
+	}{
+		{
+			name:       "pinned tag through a base stage",
+			dockerfile: "FROM docker.io/hugomods/hugo:std AS base\nFROM base AS prod\n",
+			target:     "prod",
+			image:      "docker.io/hugomods/hugo:std",
+		},
+		{
+			name:       "build arg",
+			dockerfile: "ARG HUGO_VERSION=std\nFROM hugomods/hugo:${HUGO_VERSION} AS base\nFROM base AS prod\n",
+			target:     "PROD",
+			buildArgs:  map[string]string{"HUGO_VERSION": "0.146.0"},
+			image:      "docker.io/hugomods/hugo:0.146.0",
+			usesArg:    true,
+		},
+		{
+			name:       "ARG default",
+			dockerfile: "ARG HUGO_VERSION=exts-0.145.0\nFROM --platform=$BUILDPLATFORM hugomods/hugo:$HUGO_VERSION\n",
+			image:      "docker.io/hugomods/hugo:exts-0.145.0",
+			usesArg:    true,
+		},
+		{
+			name:       "continued FROM without a tag",
+			dockerfile: "FROM hugomods/hugo \\\n  AS base\nFROM base as prod\n",
+			target:     "prod",
+			image:      "docker.io/hugomods/hugo:latest",
+		},
+		{
+			name:       "other base image",
+			dockerfile: "FROM docker.io/hugomods/hugo:std AS base\nFROM alpine:3.20 AS prod\n",
+			target:     "prod",
+		},
+	}
+	for _, tt := range tests {
+		df, err := dockerinternal.ParseDockerfile(tt.dockerfile)
+		if err != nil {
+			t.Errorf("%s: ParseDockerfile: %v", tt.name, err)
+			continue
+		}
+		base, ok := df.HugoBase(tt.target, tt.buildArgs)
+		if tt.image == "" {
+			if ok {
+				t.Errorf("%s: expected no hugomods/hugo base, got %+v", tt.name, base)
+			}
+			continue
+		}
+		if !ok || base.Image != tt.image || base.UsesArg != tt.usesArg {
+			t.Errorf("%s: expected %s (uses arg %v), got %+v", tt.name, tt.image, tt.usesArg, base)
+		}
+	}
+}
+
+func TestDockerfileHugoVersionWarnings(t *testing.T) {
+	tests := []struct {
+		name       string
+		dockerfile string
+		version    string
+		warning    string
+	}{
+		{"matching pin", "FROM hugomods/hugo:std AS prod\n", "std", ""},
+		{"different pin", "FROM hugomods/hugo:std AS prod\n", "0.146.0", "line 1 pins hugomods/hugo:std but --hugo-version is 0.146.0"},
+		{"build arg", "ARG HUGO_VERSION\nFROM hugomods/hugo:${HUGO_VERSION} AS prod\n", "0.146.0", ""},
+		{"undeclared build arg", "FROM hugomods/hugo:${HUGO_VERSION} AS prod\n", "0.146.0", "does not declare ARG HUGO_VERSION before the first FROM"},
+		{"stage ARG", "FROM alpine AS prod\nARG HUGO_VERSION\nRUN install-hugo $HUGO_VERSION\n", "0.146.0", ""},
+		{"no Hugo", "FROM alpine AS prod\n", "std", "--hugo-version std has no effect"},
+	}
+	for _, tt := range tests {
+		df, err := dockerinternal.ParseDockerfile(tt.dockerfile)
+		if err != nil {
+			t.Errorf("%s: ParseDockerfile: %v", tt.name, err)
+			continue
+		}
+		warnings := df.HugoVersionWarnings("prod", tt.version)
+		switch {
+		case tt.warning == "" && len(warnings) > 0:
+			t.Errorf("%s: expected no warning, got %v", tt.name, warnings)
+		case tt.warning != "" && (len(warnings) != 1 || !strings.Contains(warnings[0], tt.warning)):
+			t.Errorf("%s: expected a warning containing %q, got %v", tt.name, tt.warning, warnings)
+		}
+	}
+}
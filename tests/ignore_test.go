@@ -0,0 +1,91 @@
+package dockerinternal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{"basename any depth", []string{"*.log"}, "content/debug.log", false, true},
+		{"basename root", []string{"*.log"}, "debug.log", false, true},
+		{"basename no match", []string{"*.log"}, "content/debug.md", false, false},
+		{"dir only matches dir", []string{"build/"}, "build", true, true},
+		{"dir only skips file", []string{"build/"}, "build", false, false},
+		{"file inside ignored dir", []string{"build/"}, "build/out/index.html", false, true},
+		{"nested dir name", []string{"node_modules/"}, "themes/x/node_modules/pkg/a.js", false, true},
+		{"anchored leading slash", []string{"/public/"}, "public/index.html", false, true},
+		{"anchored not nested", []string{"/public/"}, "content/public/page.md", false, false},
+		{"anchored middle slash", []string{"resources/_gen"}, "resources/_gen/images/a.png", false, true},
+		{"anchored middle slash nested", []string{"resources/_gen"}, "x/resources/_gen/a.png", false, false},
+		{"question mark", []string{"file?.md"}, "content/file1.md", false, true},
+		{"question mark no slash", []string{"a?b"}, "a/b", false, false},
+		{"star no slash", []string{"/content/*.md"}, "content/sub/page.md", false, false},
+		{"character class", []string{"img[0-9].png"}, "static/img7.png", false, true},
+		{"negated class", []string{"img[!0-9].png"}, "static/img7.png", false, false},
+		{"leading double star", []string{"**/drafts"}, "content/a/drafts", true, true},
+		{"trailing double star", []string{"static/**"}, "static/a/b/c.png", false, true},
+		{"middle double star", []string{"content/**/tmp.md"}, "content/a/b/tmp.md", false, true},
+		{"middle double star zero dirs", []string{"content/**/tmp.md"}, "content/tmp.md", false, true},
+		{"negation re-includes", []string{"*.md", "!keep.md"}, "content/keep.md", false, false},
+		{"negation order matters", []string{"!keep.md", "*.md"}, "content/keep.md", false, true},
+		{"negation cannot escape ignored dir", []string{"drafts/", "!drafts/keep.md"}, "drafts/keep.md", false, true},
+		{"comment skipped", []string{"# *.md"}, "page.md", false, false},
+		{"escaped hash", []string{`\#notes.md`}, "#notes.md", false, true},
+		{"blank line skipped", []string{"", "   "}, "page.md", false, false},
+		{"root never ignored", []string{"*"}, ".", true, false},
+		{"backslash separators", []string{"/public/"}, `public\index.html`, false, filepath.Separator == '\\'},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := dockerinternal.NewIgnoreMatcher(tt.patterns)
+			if result := m.Match(tt.path, tt.isDir); result != tt.expected {
+				t.Errorf("patterns %q, path %q: expected %v, got %v", tt.patterns, tt.path, tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestLoadIgnoreMatcher(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*.tmp\n!keep.tmp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, dockerinternal.WatchIgnoreFile), []byte("assets/raw/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := dockerinternal.LoadIgnoreMatcher(dir, []string{"*.psd"})
+	if err != nil {
+		t.Fatalf("LoadIgnoreMatcher: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		isDir    bool
+		expected bool
+	}{
+		{".git", true, true},
+		{"public/index.html", false, true},
+		{"resources/_gen/images/a.png", false, true},
+		{"content/a.tmp", false, true},
+		{"content/keep.tmp", false, false},
+		{"assets/raw", true, true},
+		{"static/banner.psd", false, true},
+		{"content/_index.md", false, false},
+	}
+	for _, tt := range tests {
+		if result := m.Match(tt.path, tt.isDir); result != tt.expected {
+			t.Errorf("path %q: expected %v, got %v", tt.path, tt.expected, result)
+		}
+	}
+}
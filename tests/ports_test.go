@@ -0,0 +1,94 @@
+package dockerinternal_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+	"fortihugorunner/dockerinternal/dockerfake"
+)
+
+func listenAnyPort(t *testing.T) (net.Listener, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln, ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestResolveHostPort_InUse(t *testing.T) {
+	_, port := listenAnyPort(t)
+	cfg := dockerinternal.ServerConfig{HostPort: strconv.Itoa(port), ContainerPort: "1313"}
+
+	err := dockerinternal.ResolveHostPort(context.Background(), dockerfake.New(), &cfg, false)
+	var inUse *dockerinternal.PortInUseError
+	if !errors.As(err, &inUse) {
+		t.Fatalf("expected PortInUseError, got %v", err)
+	}
+	if inUse.Port != strconv.Itoa(port) {
+		t.Errorf("expected port %d in error, got %s", port, inUse.Port)
+	}
+}
+
+func TestResolveHostPort_InUseByContainer(t *testing.T) {
+	// A port published by a container is taken even if nothing listens on it
+	// locally, e.g. on a remote Docker host.
+	ln, port := listenAnyPort(t)
+	ln.Close()
+	fake := dockerfake.New()
+	running := testServerConfig(t)
+	running.HostPort = strconv.Itoa(port)
+	if _, err := dockerinternal.StartContainer(context.Background(), fake, running); err != nil {
+		t.Fatalf("StartContainer: %v", err)
+	}
+
+	cfg := dockerinternal.ServerConfig{HostPort: strconv.Itoa(port), ContainerPort: "1313"}
+	err := dockerinternal.ResolveHostPort(context.Background(), fake, &cfg, false)
+	var inUse *dockerinternal.PortInUseError
+	if !errors.As(err, &inUse) || !strings.HasPrefix(inUse.Holder, "container "+dockerinternal.ContainerName(running.WatchDir)) {
+		t.Fatalf("expected the port to be held by the container, got %v", err)
+	}
+}
+
+func TestResolveHostPort_Fallback(t *testing.T) {
+	_, port := listenAnyPort(t)
+	cfg := dockerinternal.ServerConfig{HostPort: strconv.Itoa(port), ContainerPort: "1313"}
+
+	if err := dockerinternal.ResolveHostPort(context.Background(), dockerfake.New(), &cfg, true); err != nil {
+		t.Fatalf("ResolveHostPort: %v", err)
+	}
+	chosen, err := strconv.Atoi(cfg.HostPort)
+	if err != nil {
+		t.Fatalf("expected numeric port, got %q", cfg.HostPort)
+	}
+	if chosen <= port {
+		t.Errorf("expected a port above %d, got %d", port, chosen)
+	}
+}
+
+func TestResolveHostPort_Auto(t *testing.T) {
+	_, port := listenAnyPort(t)
+	cfg := dockerinternal.ServerConfig{HostPort: "auto", ContainerPort: strconv.Itoa(port)}
+
+	if err := dockerinternal.ResolveHostPort(context.Background(), dockerfake.New(), &cfg, false); err != nil {
+		t.Fatalf("ResolveHostPort: %v", err)
+	}
+	if cfg.HostPort == "auto" || cfg.HostPort == strconv.Itoa(port) {
+		t.Errorf("expected a free port other than %d, got %s", port, cfg.HostPort)
+	}
+}
+
+func TestResolveHostPort_Invalid(t *testing.T) {
+	for _, hostPort := range []string{"http", "0", "70000"} {
+		cfg := dockerinternal.ServerConfig{HostPort: hostPort, ContainerPort: "1313"}
+		if err := dockerinternal.ResolveHostPort(context.Background(), dockerfake.New(), &cfg, false); err == nil {
+			t.Errorf("host port %q: expected error", hostPort)
+		}
+	}
+}
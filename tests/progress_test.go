@@ -0,0 +1,116 @@
+package dockerinternal_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+)
+
+const testPullStream = `{"status":"Pulling from k4n6m5h8/fortinet-hugo","id":"latest"}
+{"status":"Pulling fs layer","id":"a1b2c3"}
+{"status":"Pulling fs layer","id":"d4e5f6"}
+{"status":"Downloading","progressDetail":{"current":1048576,"total":4194304},"progress":"[====>   ]","id":"a1b2c3"}
+{"status":"Downloading","progressDetail":{"current":4194304,"total":4194304},"progress":"[========>]","id":"a1b2c3"}
+{"status":"Download complete","id":"a1b2c3"}
+{"status":"Pull complete","id":"a1b2c3"}
+{"status":"Pull complete","id":"d4e5f6"}
+{"status":"Digest: sha256:0123"}
+{"status":"Status: Downloaded newer image for fortinet-hugo:latest"}
+`
+
+func TestDisplayJSONMessagesPlain(t *testing.T) {
+	var out bytes.Buffer
+	if err := dockerinternal.DisplayJSONMessages(strings.NewReader(testPullStream), &out, false); err != nil {
+		t.Fatalf("DisplayJSONMessages: %v", err)
+	}
+	want := `latest: Pulling from k4n6m5h8/fortinet-hugo
+a1b2c3: Pulling fs layer
+d4e5f6: Pulling fs layer
+a1b2c3: Download complete
+a1b2c3: Pull complete
+d4e5f6: Pull complete
+Digest: sha256:0123
+Status: Downloaded newer image for fortinet-hugo:latest
+`
+	if out.String() != want {
+		t.Errorf("unexpected plain output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestDisplayJSONMessagesTTY(t *testing.T) {
+	var out bytes.Buffer
+	if err := dockerinternal.DisplayJSONMessages(strings.NewReader(testPullStream), &out, true); err != nil {
+		t.Fatalf("DisplayJSONMessages: %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		// a1b2c3 is redrawn two lines up, below the "latest" line.
+		"\x1b[2A\r\x1b[2Ka1b2c3: Downloading [=======>                      ]   1.0MiB/4.0MiB\x1b[2B\r",
+		"\x1b[2A\r\x1b[2Ka1b2c3: Pull complete\x1b[2B\r",
+		"\x1b[1A\r\x1b[2Kd4e5f6: Pull complete\x1b[1B\r",
+		"\x1b[2KStatus: Downloaded newer image for fortinet-hugo:latest\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in TTY output:\n%q", want, got)
+		}
+	}
+	if strings.Contains(got, "progressDetail") {
+		t.Errorf("expected no raw JSON in the output, got %q", got)
+	}
+}
+
+func TestDisplayJSONMessagesBuildStream(t *testing.T) {
+	stream := `{"stream":"Step 1/2 : FROM base"}
+{"stream":"\n"}
+{"stream":" ---> 0123abcd\n"}
+{"aux":{"ID":"sha256:0123abcd"}}
+{"stream":"Successfully built 0123abcd\n"}
+`
+	var out bytes.Buffer
+	if err := dockerinternal.DisplayJSONMessages(strings.NewReader(stream), &out, false); err != nil {
+		t.Fatalf("DisplayJSONMessages: %v", err)
+	}
+	want := "Step 1/2 : FROM base\n ---> 0123abcd\nSuccessfully built 0123abcd\n"
+	if out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+}
+
+func TestDisplayJSONMessagesErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		stream   string
+		wantCode int
+		wantMsg  string
+	}{
+		{
+			name:     "errorDetail",
+			stream:   `{"stream":"Step 2/2 : RUN false\n"}` + "\n" + `{"errorDetail":{"code":1,"message":"The command '/bin/sh -c false' returned a non-zero code: 1"},"error":"The command '/bin/sh -c false' returned a non-zero code: 1"}`,
+			wantCode: 1,
+			wantMsg:  "The command '/bin/sh -c false' returned a non-zero code: 1",
+		},
+		{
+			name:    "plain error",
+			stream:  `{"error":"manifest unknown"}`,
+			wantMsg: "manifest unknown",
+		},
+	}
+	for _, tt := range tests {
+		err := dockerinternal.DisplayJSONMessages(strings.NewReader(tt.stream), &bytes.Buffer{}, false)
+		var streamErr *dockerinternal.StreamError
+		if !errors.As(err, &streamErr) {
+			t.Errorf("%s: expected a StreamError, got %v", tt.name, err)
+			continue
+		}
+		if streamErr.Code != tt.wantCode || streamErr.Message != tt.wantMsg {
+			t.Errorf("%s: expected code %d %q, got %+v", tt.name, tt.wantCode, tt.wantMsg, streamErr)
+		}
+	}
+
+	if err := dockerinternal.DisplayJSONMessages(strings.NewReader(`{"status":`), &bytes.Buffer{}, false); err == nil {
+		t.Error("expected an error for a truncated stream")
+	}
+}
@@ -0,0 +1,99 @@
+package dockerinternal_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"fortihugorunner/dockerinternal"
+	"fortihugorunner/dockerinternal/dockerfake"
+)
+
+// startHugoServer answers 503 to the first notReady requests and 200 after.
+// A negative notReady never answers 200.
+func startHugoServer(t *testing.T, notReady int) string {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := int(requests.Add(1)); notReady < 0 || n <= notReady {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestWaitForReady(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	tests := []struct {
+		name     string
+		notReady int
+		timeout  time.Duration
+		wantErr  error
+	}{
+		{name: "ready", notReady: 0, timeout: 5 * time.Second},
+		{name: "ready after retries", notReady: 2, timeout: 5 * time.Second},
+		{name: "timeout", notReady: -1, timeout: 200 * time.Millisecond, wantErr: dockerinternal.ErrNotReady},
+	}
+	for _, tt := range tests {
+		url := startHugoServer(t, tt.notReady)
+		fake := dockerfake.New()
+		fake.AddContainer(dockerfake.Container{ID: id, Name: "hugo", Running: true})
+
+		err := dockerinternal.WaitForReady(context.Background(), fake, id, url, tt.timeout, 20)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestWaitForReadyContainerExited(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	url := startHugoServer(t, -1)
+	fake := dockerfake.New()
+	fake.AddContainer(dockerfake.Container{
+		ID:      id,
+		Name:    "hugo",
+		Running: true,
+		Logs:    "Start building sites …\nError: error building site: failed to render pages\n",
+	})
+	fake.SetExited(id, 255)
+
+	start := time.Now()
+	err := dockerinternal.WaitForReady(context.Background(), fake, id, url, 10*time.Second, 20)
+	var exited *dockerinternal.ContainerExitedError
+	if !errors.As(err, &exited) {
+		t.Fatalf("expected a ContainerExitedError, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("expected WaitForReady to return as soon as the container exited")
+	}
+	if exited.ExitCode != 255 || exited.ContainerID != id {
+		t.Errorf("unexpected error fields: %+v", exited)
+	}
+	if want := "Start building sites …\nError: error building site: failed to render pages"; exited.Logs != want {
+		t.Errorf("expected the log tail %q, got %q", want, exited.Logs)
+	}
+	if msg := exited.Error(); !strings.Contains(msg, id[:12]) || strings.Contains(msg, id) || !strings.Contains(msg, "code 255") {
+		t.Errorf("expected the short ID and exit code in %q", msg)
+	}
+}
+
+func TestContainerLogTail(t *testing.T) {
+	fake := dockerfake.New()
+	fake.AddContainer(dockerfake.Container{ID: "abc", Name: "hugo", Logs: "one\r\ntwo\r\n\r\n"})
+
+	logs, err := dockerinternal.ContainerLogTail(context.Background(), fake, "abc", 2)
+	if err != nil || logs != "one\r\ntwo" {
+		t.Errorf("expected the trailing newlines trimmed, got %q, %v", logs, err)
+	}
+	if _, err := dockerinternal.ContainerLogTail(context.Background(), fake, "missing", 2); err == nil {
+		t.Error("expected an error for a missing container")
+	}
+}
@@ -0,0 +1,489 @@
+package dockerinternal_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+	"fortihugorunner/dockerinternal/dockerfake"
+)
+
+const testManifest = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`
+
+var testManifestDigest = func() string {
+	sum := sha256.Sum256([]byte(testManifest))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}()
+
+// testRegistry is an httptest stand-in for an OCI distribution registry
+// serving one manifest for team/fortinet-hugo:latest.
+type testRegistry struct {
+	// auth is "", "bearer" or "basic".
+	auth string
+	// omitDigest leaves out the Docker-Content-Digest header.
+	omitDigest bool
+	// body and contentType replace testManifest.
+	body        string
+	contentType string
+	// tokenScopes records the scopes requested from the token endpoint.
+	tokenScopes []string
+	// manifestRequests counts the manifest requests served.
+	manifestRequests int
+	url              string
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.tokenScopes = append(r.tokenScopes, req.URL.Query().Get("scope"))
+		if req.URL.Query().Get("service") != "test-registry" {
+			http.Error(w, "bad service", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"token":"test-token"}`)
+		return
+	}
+
+	switch r.auth {
+	case "bearer":
+		if req.Header.Get("Authorization") != "Bearer test-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry",scope="repository:team/fortinet-hugo:pull,push"`, r.url))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	case "basic":
+		if user, pass, ok := req.BasicAuth(); !ok || user != "alice" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test-registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if req.URL.Path != "/v2/team/fortinet-hugo/manifests/latest" {
+		http.NotFound(w, req)
+		return
+	}
+	if !strings.Contains(req.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+		http.Error(w, "missing OCI media types in Accept", http.StatusBadRequest)
+		return
+	}
+	r.manifestRequests++
+	body := testManifest
+	if r.body != "" {
+		body = r.body
+	}
+	if r.contentType != "" {
+		w.Header().Set("Content-Type", r.contentType)
+	}
+	if !r.omitDigest {
+		sum := sha256.Sum256([]byte(body))
+		w.Header().Set("Docker-Content-Digest", "sha256:"+hex.EncodeToString(sum[:]))
+	}
+	fmt.Fprint(w, body)
+}
+
+// startTestRegistry starts reg over TLS and returns its host:port and a
+// Registry that trusts it.
+func startTestRegistry(t *testing.T, reg *testRegistry) (string, *dockerinternal.Registry) {
+	srv := httptest.NewTLSServer(reg)
+	t.Cleanup(srv.Close)
+	reg.url = srv.URL
+	return strings.TrimPrefix(srv.URL, "https://"), &dockerinternal.Registry{HTTPClient: srv.Client()}
+}
+
+func TestParseImageRef(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	tests := []struct {
+		input  string
+		want   dockerinternal.ImageRef
+		String string
+	}{
+		{"alpine", dockerinternal.ImageRef{Domain: "docker.io", Path: "library/alpine", Tag: "latest"}, "docker.io/library/alpine:latest"},
+		{"hugomods/hugo:std", dockerinternal.ImageRef{Domain: "docker.io", Path: "hugomods/hugo", Tag: "std"}, "docker.io/hugomods/hugo:std"},
+		{"ghcr.io/team/fortinet-hugo:v1", dockerinternal.ImageRef{Domain: "ghcr.io", Path: "team/fortinet-hugo", Tag: "v1"}, "ghcr.io/team/fortinet-hugo:v1"},
+		{"localhost:5000/fortinet-hugo", dockerinternal.ImageRef{Domain: "localhost:5000", Path: "fortinet-hugo", Tag: "latest"}, "localhost:5000/fortinet-hugo:latest"},
+		{"public.ecr.aws/k4n6m5h8/hugotester:latest", dockerinternal.ImageRef{Domain: "public.ecr.aws", Path: "k4n6m5h8/hugotester", Tag: "latest"}, "public.ecr.aws/k4n6m5h8/hugotester:latest"},
+		{"registry.example.com:8443/a/b/c@" + digest, dockerinternal.ImageRef{Domain: "registry.example.com:8443", Path: "a/b/c", Digest: digest}, "registry.example.com:8443/a/b/c@" + digest},
+	}
+	for _, tt := range tests {
+		got, err := dockerinternal.ParseImageRef(tt.input)
+		if err != nil {
+			t.Errorf("ParseImageRef(%q): unexpected error %v", tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseImageRef(%q): expected %+v, got %+v", tt.input, tt.want, got)
+		}
+		if got.String() != tt.String {
+			t.Errorf("ParseImageRef(%q).String(): expected %q, got %q", tt.input, tt.String, got.String())
+		}
+	}
+
+	for _, bad := range []string{"", "Fortinet-Hugo", "image:tag:extra", "https://example.com/image"} {
+		if _, err := dockerinternal.ParseImageRef(bad); err == nil {
+			t.Errorf("ParseImageRef(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestRunnerImageRef(t *testing.T) {
+	tests := []struct {
+		image    string
+		registry string
+		want     string
+		ok       bool
+	}{
+		{"fortinet-hugo:latest", dockerinternal.DefaultRegistry, "public.ecr.aws/k4n6m5h8/fortinet-hugo:latest", true},
+		{"hugotester", "ghcr.io/my-team", "ghcr.io/my-team/hugotester:latest", true},
+		{"registry.example.com:5000/mirror/fortinet-hugo:v2", dockerinternal.DefaultRegistry, "registry.example.com:5000/mirror/fortinet-hugo:v2", true},
+		{"hugomods/hugo:std", dockerinternal.DefaultRegistry, "", false},
+		{"not a reference", dockerinternal.DefaultRegistry, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := dockerinternal.RunnerImageRef(tt.image, tt.registry)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("RunnerImageRef(%q, %q): expected (%q, %v), got (%q, %v)", tt.image, tt.registry, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestManifestDigest(t *testing.T) {
+	tests := []struct {
+		name       string
+		registry   testRegistry
+		user, pass string
+		wantErr    error
+	}{
+		{name: "anonymous", registry: testRegistry{}},
+		{name: "digest from body", registry: testRegistry{omitDigest: true}},
+		{name: "bearer token", registry: testRegistry{auth: "bearer"}},
+		{name: "bearer token with credentials", registry: testRegistry{auth: "bearer"}, user: "alice", pass: "secret"},
+		{name: "basic", registry: testRegistry{auth: "basic"}, user: "alice", pass: "secret"},
+		{name: "basic without credentials", registry: testRegistry{auth: "basic"}, wantErr: dockerinternal.ErrRegistryAuth},
+		{name: "basic with wrong password", registry: testRegistry{auth: "basic"}, user: "alice", pass: "wrong", wantErr: dockerinternal.ErrRegistryAuth},
+	}
+	for _, tt := range tests {
+		host, reg := startTestRegistry(t, &tt.registry)
+		reg.Credentials = func(string) (string, string, error) { return tt.user, tt.pass, nil }
+		ref, err := dockerinternal.ParseImageRef(host + "/team/fortinet-hugo")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		digest, err := reg.ManifestDigest(context.Background(), ref)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s: expected %v, got %v", tt.name, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if digest != testManifestDigest {
+			t.Errorf("%s: expected digest %s, got %s", tt.name, testManifestDigest, digest)
+		}
+		if tt.registry.auth == "bearer" && !slices.Equal(tt.registry.tokenScopes, []string{"repository:team/fortinet-hugo:pull,push"}) {
+			t.Errorf("%s: unexpected token scopes %v", tt.name, tt.registry.tokenScopes)
+		}
+	}
+}
+
+func TestManifestDigestNotFound(t *testing.T) {
+	host, reg := startTestRegistry(t, &testRegistry{})
+	ref, _ := dockerinternal.ParseImageRef(host + "/team/missing:latest")
+
+	_, err := reg.ManifestDigest(context.Background(), ref)
+	var regErr *dockerinternal.RegistryError
+	if !errors.As(err, &regErr) || regErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a 404 RegistryError, got %v", err)
+	}
+}
+
+func TestManifestDigestInsecureRegistry(t *testing.T) {
+	srv := httptest.NewServer(&testRegistry{})
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	ref, _ := dockerinternal.ParseImageRef(host + "/team/fortinet-hugo:latest")
+
+	if _, err := dockerinternal.NewRegistry(nil).ManifestDigest(context.Background(), ref); err == nil {
+		t.Error("expected a plain HTTP registry to be rejected unless marked insecure")
+	}
+	digest, err := dockerinternal.NewRegistry([]string{host}).ManifestDigest(context.Background(), ref)
+	if err != nil {
+		t.Fatalf("insecure registry: unexpected error %v", err)
+	}
+	if digest != testManifestDigest {
+		t.Errorf("expected digest %s, got %s", testManifestDigest, digest)
+	}
+}
+
+func TestManifestDigestPinned(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("b", 64)
+	ref, _ := dockerinternal.ParseImageRef("registry.invalid/team/fortinet-hugo@" + digest)
+
+	got, err := dockerinternal.NewRegistry(nil).ManifestDigest(context.Background(), ref)
+	if err != nil || got != digest {
+		t.Errorf("expected pinned digest %s without contacting the registry, got %q, %v", digest, got, err)
+	}
+}
+
+func TestLocalImageCheck(t *testing.T) {
+	host, reg := startTestRegistry(t, &testRegistry{})
+	remote := host + "/team/fortinet-hugo:latest"
+
+	tests := []struct {
+		name        string
+		localDigest string
+		wantPull    bool
+	}{
+		{"up to date", testManifestDigest, false},
+		{"outdated", "sha256:" + strings.Repeat("0", 64), true},
+		{"not present", "", true},
+	}
+	for _, tt := range tests {
+		fake := dockerfake.New()
+		if tt.localDigest != "" {
+			fake.AddImage(dockerfake.Image{
+				Ref:         remote,
+				ID:          "sha256:local",
+				RepoDigests: []string{host + "/team/fortinet-hugo@" + tt.localDigest},
+			})
+		}
+
+		if err := dockerinternal.LocalImageCheck(fake, reg, remote, "fortinet-hugo:latest"); err != nil {
+			t.Fatalf("%s: LocalImageCheck: %v", tt.name, err)
+		}
+		calls := fake.Calls()
+		pulled := slices.Contains(calls, "ImagePull "+remote)
+		tagged := slices.Contains(calls, "ImageTag "+remote+" fortinet-hugo:latest")
+		if pulled != tt.wantPull || tagged != tt.wantPull {
+			t.Errorf("%s: expected pull and tag %v, got calls %v", tt.name, tt.wantPull, calls)
+		}
+	}
+}
+
+var (
+	amd64Digest = "sha256:" + strings.Repeat("1", 64)
+	arm64Digest = "sha256:" + strings.Repeat("2", 64)
+	armv7Digest = "sha256:" + strings.Repeat("3", 64)
+)
+
+// testIndex is a multi-arch OCI index with a buildx attestation entry.
+var testIndex = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.index.v1+json",
+  "manifests": [
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "` + amd64Digest + `", "size": 1, "platform": {"architecture": "amd64", "os": "linux"}},
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "` + arm64Digest + `", "size": 1, "platform": {"architecture": "arm64", "os": "linux", "variant": "v8"}},
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "` + armv7Digest + `", "size": 1, "platform": {"architecture": "arm", "os": "linux", "variant": "v7"}},
+    {"mediaType": "application/vnd.oci.image.manifest.v1+json", "digest": "sha256:` + strings.Repeat("4", 64) + `", "size": 1, "platform": {"architecture": "unknown", "os": "unknown"}}
+  ]
+}`
+
+var testIndexDigest = func() string {
+	sum := sha256.Sum256([]byte(testIndex))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}()
+
+func TestManifestIndex(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{"OCI index", "application/vnd.oci.image.index.v1+json"},
+		{"Docker manifest list", "application/vnd.docker.distribution.manifest.list.v2+json"},
+		{"media type from body", ""},
+	}
+	for _, tt := range tests {
+		host, reg := startTestRegistry(t, &testRegistry{body: testIndex, contentType: tt.contentType})
+		ref, _ := dockerinternal.ParseImageRef(host + "/team/fortinet-hugo:latest")
+
+		m, err := reg.Manifest(context.Background(), ref)
+		if err != nil {
+			t.Fatalf("%s: Manifest: %v", tt.name, err)
+		}
+		if !m.IsIndex() {
+			t.Errorf("%s: expected an index, got media type %q", tt.name, m.MediaType)
+		}
+		if m.Digest != testIndexDigest {
+			t.Errorf("%s: expected the index digest %s, got %s", tt.name, testIndexDigest, m.Digest)
+		}
+		want := []string{"linux/amd64", "linux/arm64/v8", "linux/arm/v7"}
+		if got := m.Platforms(); !slices.Equal(got, want) {
+			t.Errorf("%s: expected platforms %v, got %v", tt.name, want, got)
+		}
+	}
+}
+
+func TestRemoteManifestForPlatform(t *testing.T) {
+	m := &dockerinternal.RemoteManifest{
+		Digest:    testIndexDigest,
+		MediaType: "application/vnd.oci.image.index.v1+json",
+		Manifests: []dockerinternal.PlatformManifest{
+			{Digest: amd64Digest, Platform: dockerinternal.Platform{OS: "linux", Architecture: "amd64"}},
+			{Digest: arm64Digest, Platform: dockerinternal.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+			{Digest: armv7Digest, Platform: dockerinternal.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		},
+	}
+	tests := []struct {
+		platform dockerinternal.Platform
+		want     string
+	}{
+		{dockerinternal.Platform{OS: "linux", Architecture: "amd64"}, amd64Digest},
+		{dockerinternal.Platform{OS: "linux", Architecture: "arm64"}, arm64Digest},
+		{dockerinternal.Platform{OS: "linux", Architecture: "arm"}, armv7Digest},
+		{dockerinternal.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}, armv7Digest},
+		{dockerinternal.Platform{OS: "windows", Architecture: "amd64"}, ""},
+	}
+	for _, tt := range tests {
+		entry, ok := m.ForPlatform(tt.platform)
+		if ok != (tt.want != "") || entry.Digest != tt.want {
+			t.Errorf("ForPlatform(%s): expected %q, got %q (ok %v)", tt.platform, tt.want, entry.Digest, ok)
+		}
+	}
+
+	arm64 := dockerinternal.Platform{OS: "linux", Architecture: "arm64"}
+	for digest, want := range map[string]bool{testIndexDigest: true, arm64Digest: true, amd64Digest: false, "": false} {
+		if got := m.Matches(digest, arm64); got != want {
+			t.Errorf("Matches(%q, %s): expected %v, got %v", digest, arm64, want, got)
+		}
+	}
+}
+
+func TestLocalImageCheckMultiArch(t *testing.T) {
+	host, reg := startTestRegistry(t, &testRegistry{body: testIndex, contentType: "application/vnd.oci.image.index.v1+json"})
+	remote := host + "/team/fortinet-hugo:latest"
+
+	tests := []struct {
+		name        string
+		localDigest string
+		wantPull    bool
+	}{
+		{"index digest", testIndexDigest, false},
+		{"platform digest", arm64Digest, false},
+		{"other platform's digest", amd64Digest, true},
+	}
+	for _, tt := range tests {
+		fake := dockerfake.New()
+		fake.Arch = "arm64"
+		fake.AddImage(dockerfake.Image{
+			Ref:         remote,
+			ID:          "sha256:local",
+			RepoDigests: []string{host + "/team/fortinet-hugo@" + tt.localDigest},
+		})
+
+		if err := dockerinternal.LocalImageCheck(fake, reg, remote, ""); err != nil {
+			t.Fatalf("%s: LocalImageCheck: %v", tt.name, err)
+		}
+		if pulled := slices.Contains(fake.Calls(), "ImagePull "+remote); pulled != tt.wantPull {
+			t.Errorf("%s: expected pull %v, got calls %v", tt.name, tt.wantPull, fake.Calls())
+		}
+	}
+}
+
+// unreachableHost returns a host:port nothing listens on.
+func unreachableHost(t *testing.T) string {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	host := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+	return host
+}
+
+func TestLocalImageCheckOffline(t *testing.T) {
+	remote := unreachableHost(t) + "/team/fortinet-hugo:latest"
+
+	tests := []struct {
+		name     string
+		local    string
+		wantErr  error
+		wantPull bool
+	}{
+		{name: "local image", local: "fortinet-hugo:latest"},
+		{name: "no local image", wantErr: dockerinternal.ErrNoLocalImage},
+		{name: "only the remote name", local: remote, wantErr: dockerinternal.ErrNoLocalImage},
+	}
+	for _, tt := range tests {
+		fake := dockerfake.New()
+		if tt.local != "" {
+			fake.AddImage(dockerfake.Image{Ref: tt.local, ID: "sha256:local"})
+		}
+
+		err := dockerinternal.LocalImageCheck(fake, &dockerinternal.Registry{}, remote, "fortinet-hugo:latest")
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.wantErr, err)
+		}
+		if slices.Contains(fake.Calls(), "ImagePull "+remote) {
+			t.Errorf("%s: expected no pull from an unreachable registry, got calls %v", tt.name, fake.Calls())
+		}
+	}
+}
+
+func TestLocalImageCheckPullFails(t *testing.T) {
+	host, reg := startTestRegistry(t, &testRegistry{})
+	remote := host + "/team/fortinet-hugo:latest"
+
+	tests := []struct {
+		name     string
+		pullErr  error
+		hasLocal bool
+		wantErr  string
+	}{
+		{name: "network error, local image", pullErr: errors.New("connection reset by peer"), hasLocal: true},
+		{name: "network error, no local image", pullErr: errors.New(`Get "https://registry/v2/": dial tcp: lookup registry: no such host`), wantErr: dockerinternal.ErrNoLocalImage.Error()},
+		{name: "unauthorized", pullErr: errors.New("unauthorized: authentication required"), hasLocal: true, wantErr: "unauthorized"},
+		{name: "manifest unknown", pullErr: errors.New("manifest unknown"), hasLocal: true, wantErr: "manifest unknown"},
+		{name: "disk full", pullErr: errors.New("write /var/lib/docker: no space left on device"), hasLocal: true, wantErr: "no space left"},
+	}
+	for _, tt := range tests {
+		fake := dockerfake.New()
+		fake.PullErrors = map[string]error{remote: tt.pullErr}
+		if tt.hasLocal {
+			fake.AddImage(dockerfake.Image{Ref: "fortinet-hugo:latest", ID: "sha256:local"})
+		}
+
+		err := dockerinternal.LocalImageCheck(fake, reg, remote, "fortinet-hugo:latest")
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: expected the outdated local image to be used, got %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestIsRegistryUnreachable(t *testing.T) {
+	ref, _ := dockerinternal.ParseImageRef(unreachableHost(t) + "/team/fortinet-hugo:latest")
+	_, refused := (&dockerinternal.Registry{}).Manifest(context.Background(), ref)
+
+	host, reg := startTestRegistry(t, &testRegistry{})
+	ref, _ = dockerinternal.ParseImageRef(host + "/team/missing:latest")
+	_, notFound := reg.Manifest(context.Background(), ref)
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", refused, true},
+		{"timeout", fmt.Errorf("fetching token: %w", context.DeadlineExceeded), true},
+		{"manifest not found", notFound, false},
+		{"auth failure", dockerinternal.ErrRegistryAuth, false},
+	}
+	for _, tt := range tests {
+		if tt.err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+		if got := dockerinternal.IsRegistryUnreachable(tt.err); got != tt.want {
+			t.Errorf("%s: IsRegistryUnreachable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
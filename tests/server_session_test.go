@@ -0,0 +1,242 @@
+package dockerinternal_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"fortihugorunner/dockerinternal"
+	"fortihugorunner/dockerinternal/dockerfake"
+	"github.com/moby/moby/api/types/container"
+)
+
+func testServerConfig(t *testing.T) dockerinternal.ServerConfig {
+	// Keep session leases out of the real cache directory.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	return dockerinternal.ServerConfig{
+		DockerImage:   "fortinet-hugo:latest",
+		HostPort:      "1313",
+		BindAddress:   netip.MustParseAddr("127.0.0.1"),
+		ContainerPort: "1313",
+		WatchDir:      t.TempDir(),
+		WatchMode:     dockerinternal.WatchModeRestart,
+		WatchBackend:  dockerinternal.WatchBackendPoll,
+		PollInterval:  10 * time.Millisecond,
+	}
+}
+
+func assertNoContainers(t *testing.T, fake *dockerfake.Client) {
+	t.Helper()
+	if left := fake.Containers(); len(left) != 0 {
+		t.Errorf("expected no containers to be left behind, found %d: %+v", len(left), left)
+	}
+}
+
+func TestServerSessionShutdownRemovesContainer(t *testing.T) {
+	fake := dockerfake.New()
+	session := dockerinternal.NewServerSession(fake, testServerConfig(t))
+
+	if err := session.Start(context.Background()); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if session.ContainerID() == "" {
+		t.Fatal("expected a container ID after Start")
+	}
+	session.Shutdown()
+	assertNoContainers(t, fake)
+
+	// A second shutdown is a no-op.
+	session.Shutdown()
+}
+
+func TestServerSessionRestartReplacesContainer(t *testing.T) {
+	fake := dockerfake.New()
+	session := dockerinternal.NewServerSession(fake, testServerConfig(t))
+	ctx := context.Background()
+
+	if err := session.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	first := session.ContainerID()
+	if err := session.Restart(ctx); err != nil {
+		t.Fatalf("Restart: %v", err)
+	}
+	containers := fake.Containers()
+	if len(containers) != 1 || containers[0].ID == first || containers[0].ID != session.ContainerID() {
+		t.Fatalf("expected only the restarted container to exist, got %+v", containers)
+	}
+	session.Shutdown()
+	assertNoContainers(t, fake)
+}
+
+func TestServerSessionShutdownDuringRestart(t *testing.T) {
+	fake := dockerfake.New()
+	session := dockerinternal.NewServerSession(fake, testServerConfig(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := session.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	// Simulate Ctrl-C arriving while the replacement container is being
+	// created: the context is cancelled and Shutdown runs concurrently.
+	shutdownDone := make(chan struct{})
+	fake.OnCreate = func(string) {
+		fake.OnCreate = nil
+		cancel()
+		go func() {
+			session.Shutdown()
+			close(shutdownDone)
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+	err := session.Restart(ctx)
+	if err != nil && !errors.Is(err, dockerinternal.ErrSessionClosed) {
+		t.Fatalf("Restart: unexpected error %v", err)
+	}
+
+	select {
+	case <-shutdownDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+	assertNoContainers(t, fake)
+}
+
+func TestServerSessionClosedRejectsOperations(t *testing.T) {
+	fake := dockerfake.New()
+	session := dockerinternal.NewServerSession(fake, testServerConfig(t))
+	ctx := context.Background()
+
+	session.Shutdown()
+	if err := session.Start(ctx); !errors.Is(err, dockerinternal.ErrSessionClosed) {
+		t.Errorf("Start after Shutdown: expected ErrSessionClosed, got %v", err)
+	}
+	if err := session.Restart(ctx); !errors.Is(err, dockerinternal.ErrSessionClosed) {
+		t.Errorf("Restart after Shutdown: expected ErrSessionClosed, got %v", err)
+	}
+	if err := session.Rebuild(ctx); !errors.Is(err, dockerinternal.ErrSessionClosed) {
+		t.Errorf("Rebuild after Shutdown: expected ErrSessionClosed, got %v", err)
+	}
+	assertNoContainers(t, fake)
+}
+
+func TestServerSessionKill(t *testing.T) {
+	fake := dockerfake.New()
+	session := dockerinternal.NewServerSession(fake, testServerConfig(t))
+	ctx := context.Background()
+
+	if err := session.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if err := session.Kill(ctx); err != nil {
+		t.Fatalf("Kill: %v", err)
+	}
+	assertNoContainers(t, fake)
+	if err := session.Restart(ctx); !errors.Is(err, dockerinternal.ErrSessionClosed) {
+		t.Errorf("Restart after Kill: expected ErrSessionClosed, got %v", err)
+	}
+}
+
+func TestServerSessionAdopt(t *testing.T) {
+	fake := dockerfake.New()
+	cfg := testServerConfig(t)
+	fake.AddContainer(dockerfake.Container{ID: "existing", Name: dockerinternal.ContainerName(cfg.WatchDir), Running: true})
+	session := dockerinternal.NewServerSession(fake, cfg)
+
+	if err := session.Adopt(context.Background(), "existing"); err != nil {
+		t.Fatalf("Adopt: %v", err)
+	}
+	if got := session.ContainerID(); got != "existing" {
+		t.Errorf("expected adopted container ID %q, got %q", "existing", got)
+	}
+	session.Shutdown()
+	assertNoContainers(t, fake)
+}
+
+func TestServerSessionAdoptTakesOwnership(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+	fake := dockerfake.New()
+	cfg := testServerConfig(t)
+	fake.AddContainer(dockerfake.Container{
+		ID:      "existing",
+		Name:    dockerinternal.ContainerName(cfg.WatchDir),
+		Running: true,
+		Config: &container.Config{Labels: map[string]string{
+			dockerinternal.LabelWorkshop: cfg.WatchDir,
+			dockerinternal.LabelHost:     host,
+			dockerinternal.LabelPID:      strconv.Itoa(exitedPID(t)),
+		}},
+	})
+	ctx := context.Background()
+	orphaned := func() bool {
+		t.Helper()
+		s, err := dockerinternal.FindWorkshopSession(ctx, fake, cfg.WatchDir)
+		if err != nil || s == nil {
+			t.Fatalf("FindWorkshopSession: %+v, %v", s, err)
+		}
+		return s.Orphaned()
+	}
+	if !orphaned() {
+		t.Fatal("expected the container of an exited runner to be orphaned")
+	}
+
+	session := dockerinternal.NewServerSession(fake, cfg)
+	if err := session.Adopt(ctx, "existing"); err != nil {
+		t.Fatalf("Adopt: %v", err)
+	}
+	if orphaned() {
+		t.Error("expected the adopted container to belong to this runner")
+	}
+
+	// A lease that is no longer refreshed means its runner is gone, even if
+	// the PID in it is alive again.
+	path, err := dockerinternal.LeasePath("existing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * dockerinternal.LeaseTTL)
+	if err := os.Chtimes(path, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if !orphaned() {
+		t.Error("expected a container with a stale lease to be orphaned")
+	}
+
+	session.Shutdown()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the lease to be removed on shutdown, got %v", err)
+	}
+}
+
+func TestWatchAndRestartStopsOnCancel(t *testing.T) {
+	fake := dockerfake.New()
+	session := dockerinternal.NewServerSession(fake, testServerConfig(t))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if err := session.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		dockerinternal.WatchAndRestart(ctx, session)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchAndRestart did not return after cancel")
+	}
+	session.Shutdown()
+	assertNoContainers(t, fake)
+}
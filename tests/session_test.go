@@ -0,0 +1,221 @@
+package dockerinternal_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+	"fortihugorunner/dockerinternal/dockerfake"
+	"github.com/moby/moby/api/types/container"
+)
+
+var dockerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+func TestContainerName(t *testing.T) {
+	tests := []struct {
+		dir    string
+		prefix string
+	}{
+		{"/Users/test/My Workshop", "fortihugorunner-my-workshop-"},
+		{"/home/test/fortigate-101", "fortihugorunner-fortigate-101-"},
+		{`C:\Users\test\ws`, "fortihugorunner-"},
+		{"/", "fortihugorunner-workshop-"},
+		{"/home/test/!!!", "fortihugorunner-workshop-"},
+	}
+	for _, tt := range tests {
+		name := dockerinternal.ContainerName(tt.dir)
+		if !strings.HasPrefix(name, tt.prefix) {
+			t.Errorf("ContainerName(%q): expected prefix %q, got %q", tt.dir, tt.prefix, name)
+		}
+		if !dockerNamePattern.MatchString(name) {
+			t.Errorf("ContainerName(%q): %q is not a valid Docker container name", tt.dir, name)
+		}
+		if again := dockerinternal.ContainerName(tt.dir); again != name {
+			t.Errorf("ContainerName(%q) is not deterministic: %q vs %q", tt.dir, name, again)
+		}
+	}
+
+	if dockerinternal.ContainerName("/a/workshop") == dockerinternal.ContainerName("/b/workshop") {
+		t.Error("expected different names for workshops with the same folder name")
+	}
+}
+
+// exitedPID returns the PID of a child that has exited and been reaped, so
+// it is not alive.
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	done := exec.Command(os.Args[0], "-test.run=^$")
+	if err := done.Run(); err != nil {
+		t.Fatalf("failed to run helper process: %v", err)
+	}
+	return done.ProcessState.Pid()
+}
+
+func TestSessionOrphaned(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+	deadPID := exitedPID(t)
+
+	tests := []struct {
+		name    string
+		session dockerinternal.Session
+		want    bool
+	}{
+		{"owner alive", dockerinternal.Session{State: "running", OwnerHost: host, OwnerPID: os.Getpid()}, false},
+		{"owner gone", dockerinternal.Session{State: "running", OwnerHost: host, OwnerPID: deadPID}, true},
+		{"not running", dockerinternal.Session{State: "exited", OwnerHost: host, OwnerPID: deadPID}, false},
+		{"other host", dockerinternal.Session{State: "running", OwnerHost: host + "-other", OwnerPID: deadPID}, false},
+		{"no owner label", dockerinternal.Session{State: "running"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.session.Orphaned(); got != tt.want {
+			t.Errorf("%s: Orphaned() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExistingSessionAction(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no hostname: %v", err)
+	}
+	done := exec.Command(os.Args[0], "-test.run=^$")
+	if err := done.Run(); err != nil {
+		t.Fatalf("failed to run helper process: %v", err)
+	}
+	owned := &dockerinternal.Session{Name: "ws", State: "running", OwnerHost: host, OwnerPID: os.Getpid()}
+	orphaned := &dockerinternal.Session{Name: "ws", State: "running", OwnerHost: host, OwnerPID: done.ProcessState.Pid()}
+	stopped := &dockerinternal.Session{Name: "ws", State: "exited"}
+
+	tests := []struct {
+		name    string
+		session *dockerinternal.Session
+		answers []bool
+		want    dockerinternal.SessionAction
+		asked   int
+	}{
+		{"no session", nil, nil, dockerinternal.SessionStart, 0},
+		{"stopped", stopped, nil, dockerinternal.SessionReplace, 0},
+		{"reattach", owned, []bool{true}, dockerinternal.SessionReattach, 1},
+		{"decline reattach and replace", owned, []bool{false, false}, dockerinternal.SessionAbort, 2},
+		{"decline reattach, confirm replace", owned, []bool{false, true}, dockerinternal.SessionReplace, 2},
+		{"decline reattach of orphan", orphaned, []bool{false}, dockerinternal.SessionReplace, 1},
+	}
+	for _, tt := range tests {
+		var defaults []bool
+		ask := func(question string, defaultYes bool) bool {
+			defaults = append(defaults, defaultYes)
+			return tt.answers[len(defaults)-1]
+		}
+		got := dockerinternal.ExistingSessionAction(tt.session, "http://127.0.0.1:1313", ask)
+		if got != tt.want || len(defaults) != tt.asked {
+			t.Errorf("%s: got action %d after %d questions, want %d after %d", tt.name, got, len(defaults), tt.want, tt.asked)
+		}
+		// Replacing a live session must never be the default answer.
+		if len(defaults) == 2 && defaults[1] {
+			t.Errorf("%s: expected the replace question to default to no", tt.name)
+		}
+	}
+}
+
+func TestResolveSession(t *testing.T) {
+	dirA, dirB, dirC := t.TempDir(), t.TempDir(), t.TempDir()
+	fake := dockerfake.New()
+	for _, c := range []struct {
+		id, name, workshop string
+		running            bool
+	}{
+		{"abcd1111" + strings.Repeat("0", 56), "fhr-a", dirA, true},
+		{"abcd2222" + strings.Repeat("0", 56), "fhr-b", dirB, true},
+		{"ef012345" + strings.Repeat("0", 56), "fhr-c", dirC, false},
+	} {
+		fake.AddContainer(dockerfake.Container{
+			ID:      c.id,
+			Name:    c.name,
+			Running: c.running,
+			Config:  &container.Config{Labels: map[string]string{dockerinternal.LabelWorkshop: c.workshop}},
+		})
+	}
+	fake.AddContainer(dockerfake.Container{ID: "abcd9999" + strings.Repeat("0", 56), Name: "postgres", Running: true})
+
+	tests := []struct {
+		arg     string
+		want    string
+		wantErr string
+	}{
+		{arg: dirA, want: "fhr-a"},
+		{arg: "fhr-b", want: "fhr-b"},
+		{arg: "ef01", want: "fhr-c"},
+		{arg: "abcd1111" + strings.Repeat("0", 56), want: "fhr-a"},
+		{arg: "abcd2", want: "fhr-b"},
+		{arg: "e", wantErr: "too short"},
+		{arg: "abc", wantErr: "too short"},
+		{arg: "abcd", wantErr: "matches 2 containers (fhr-a, fhr-b)"},
+		{arg: "abcd9", wantErr: "no fortihugorunner container"},
+		{arg: "missing", wantErr: "no fortihugorunner container"},
+	}
+	for _, tt := range tests {
+		session, err := dockerinternal.ResolveSession(context.Background(), fake, tt.arg)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ResolveSession(%q): expected an error containing %q, got %v, %+v", tt.arg, tt.wantErr, err, session)
+			}
+			continue
+		}
+		if err != nil || session.Name != tt.want {
+			t.Errorf("ResolveSession(%q): expected %s, got %+v, %v", tt.arg, tt.want, session, err)
+		}
+	}
+}
+
+func TestShortID(t *testing.T) {
+	id := strings.Repeat("0123456789ab", 5) + "cdef"
+	for in, want := range map[string]string{
+		id:             "0123456789ab",
+		"sha256:" + id: "0123456789ab",
+		"abc":          "abc",
+		"":             "",
+	} {
+		if got := dockerinternal.ShortID(in); got != want {
+			t.Errorf("ShortID(%q): expected %q, got %q", in, want, got)
+		}
+	}
+}
+
+func TestSessionRepoDigest(t *testing.T) {
+	mirror := "sha256:" + strings.Repeat("1", 64)
+	digest := "sha256:" + strings.Repeat("2", 64)
+	fake := dockerfake.New()
+	fake.AddImage(dockerfake.Image{
+		Ref: "fortinetsolutioncse/hugotester:latest",
+		ID:  "sha256:" + strings.Repeat("a", 64),
+		RepoDigests: []string{
+			"mirror.example.com/hugotester@" + mirror,
+			"fortinetsolutioncse/hugotester@" + digest,
+		},
+	})
+	fake.AddImage(dockerfake.Image{Ref: "fortinet-hugo:latest", ID: "sha256:" + strings.Repeat("b", 64)})
+
+	tests := []struct {
+		name    string
+		session dockerinternal.Session
+		want    string
+	}{
+		{"matching repository", dockerinternal.Session{Image: "fortinetsolutioncse/hugotester:latest", ImageID: "sha256:" + strings.Repeat("a", 64)}, digest},
+		{"by image name", dockerinternal.Session{Image: "fortinetsolutioncse/hugotester:latest"}, digest},
+		{"other repository", dockerinternal.Session{Image: "hugotester:dev", ImageID: "sha256:" + strings.Repeat("a", 64)}, mirror},
+		{"built locally", dockerinternal.Session{Image: "fortinet-hugo:latest", ImageID: "sha256:" + strings.Repeat("b", 64)}, ""},
+		{"image removed", dockerinternal.Session{Image: "gone:latest", ImageID: "sha256:" + strings.Repeat("c", 64)}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.session.RepoDigest(context.Background(), fake); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
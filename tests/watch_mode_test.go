@@ -0,0 +1,71 @@
+package dockerinternal_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"fortihugorunner/dockerinternal"
+)
+
+func TestParseWatchMode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected dockerinternal.WatchMode
+		wantErr  bool
+	}{
+		{"hugo", dockerinternal.WatchModeHugo, false},
+		{"restart", dockerinternal.WatchModeRestart, false},
+		{"rebuild", dockerinternal.WatchModeRebuild, false},
+		{"Rebuild", dockerinternal.WatchModeRebuild, false},
+		{"", "", true},
+		{"reload", "", true},
+	}
+	for _, tt := range tests {
+		result, err := dockerinternal.ParseWatchMode(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseWatchMode(%q): unexpected error state: %v", tt.input, err)
+			continue
+		}
+		if result != tt.expected {
+			t.Errorf("ParseWatchMode(%q): expected %q, got %q", tt.input, tt.expected, result)
+		}
+	}
+}
+
+func TestSelectWatchAction(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "workshop")
+	content := filepath.Join(root, "content", "_index.md")
+	static := filepath.Join(root, "static", "img", "diagram.png")
+	hugoToml := filepath.Join(root, "hugo.toml")
+	themeFile := filepath.Join(root, "themes", "fortinet", "layouts", "index.html")
+	configDir := filepath.Join(root, "config", "_default", "params.toml")
+	nestedToml := filepath.Join(root, "content", "hugo.toml")
+	outside := filepath.Join(string(filepath.Separator), "elsewhere", "hugo.toml")
+
+	tests := []struct {
+		name     string
+		mode     dockerinternal.WatchMode
+		changed  []string
+		expected dockerinternal.WatchAction
+	}{
+		{"no changes", dockerinternal.WatchModeRestart, nil, dockerinternal.WatchActionNone},
+		{"restart mode content", dockerinternal.WatchModeRestart, []string{content}, dockerinternal.WatchActionRestart},
+		{"restart mode config", dockerinternal.WatchModeRestart, []string{hugoToml}, dockerinternal.WatchActionRestart},
+		{"hugo mode content", dockerinternal.WatchModeHugo, []string{content, static}, dockerinternal.WatchActionNone},
+		{"hugo mode hugo.toml", dockerinternal.WatchModeHugo, []string{content, hugoToml}, dockerinternal.WatchActionRestart},
+		{"hugo mode theme", dockerinternal.WatchModeHugo, []string{themeFile}, dockerinternal.WatchActionRestart},
+		{"hugo mode config dir", dockerinternal.WatchModeHugo, []string{configDir}, dockerinternal.WatchActionRestart},
+		{"hugo mode nested hugo.toml", dockerinternal.WatchModeHugo, []string{nestedToml}, dockerinternal.WatchActionNone},
+		{"hugo mode outside watch dir", dockerinternal.WatchModeHugo, []string{outside}, dockerinternal.WatchActionNone},
+		{"rebuild mode content", dockerinternal.WatchModeRebuild, []string{content}, dockerinternal.WatchActionRebuild},
+		{"rebuild mode hugo.toml", dockerinternal.WatchModeRebuild, []string{hugoToml}, dockerinternal.WatchActionRestart},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := dockerinternal.SelectWatchAction(tt.mode, root, tt.changed)
+			if result != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package dockerinternal_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"fortihugorunner/dockerinternal"
+)
+
+const watchTimeout = 5 * time.Second
+
+func newTestNotifyWatcher(t *testing.T, root string) *dockerinternal.NotifyWatcher {
+	t.Helper()
+	w, err := dockerinternal.NewNotifyWatcher(root, dockerinternal.NewIgnoreMatcher(dockerinternal.DefaultWatchIgnores))
+	if err != nil {
+		t.Fatalf("NewNotifyWatcher: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// waitForChange reads changes until path is seen or the timeout expires.
+func waitForChange(t *testing.T, w *dockerinternal.NotifyWatcher, path string) {
+	t.Helper()
+	deadline := time.After(watchTimeout)
+	for {
+		select {
+		case got, ok := <-w.Changes():
+			if !ok {
+				t.Fatalf("watcher closed before change to %s", path)
+			}
+			if got == path {
+				return
+			}
+		case err := <-w.Errors():
+			t.Fatalf("watcher error: %v", err)
+		case <-deadline:
+			t.Fatalf("timed out waiting for change to %s", path)
+		}
+	}
+}
+
+// waitForDirs drains changes until the watched set satisfies cond.
+func waitForDirs(t *testing.T, w *dockerinternal.NotifyWatcher, cond func([]string) bool) {
+	t.Helper()
+	deadline := time.After(watchTimeout)
+	for !cond(w.WatchedDirs()) {
+		select {
+		case <-w.Changes():
+		case <-time.After(20 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("timed out; watched dirs: %v", w.WatchedDirs())
+		}
+	}
+}
+
+func TestNotifyWatcher_InitialDirsSkipIgnored(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"content/intro", "public/css", ".git/objects"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := newTestNotifyWatcher(t, root)
+	expected := []string{
+		root,
+		filepath.Join(root, "content"),
+		filepath.Join(root, "content", "intro"),
+	}
+	if got := w.WatchedDirs(); !slices.Equal(got, expected) {
+		t.Errorf("expected watched dirs %v, got %v", expected, got)
+	}
+}
+
+func TestNotifyWatcher_NewSubdirectoryIsWatched(t *testing.T) {
+	root := t.TempDir()
+	content := filepath.Join(root, "content")
+	if err := os.Mkdir(content, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestNotifyWatcher(t, root)
+
+	chapter := filepath.Join(content, "chapter2")
+	if err := os.Mkdir(chapter, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange(t, w, chapter)
+	waitForDirs(t, w, func(dirs []string) bool { return slices.Contains(dirs, chapter) })
+
+	page := filepath.Join(chapter, "_index.md")
+	if err := os.WriteFile(page, []byte("# Chapter 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange(t, w, page)
+}
+
+func TestNotifyWatcher_NestedNewDirectories(t *testing.T) {
+	root := t.TempDir()
+	w := newTestNotifyWatcher(t, root)
+
+	deep := filepath.Join(root, "content", "a", "b")
+	if err := os.MkdirAll(deep, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	waitForDirs(t, w, func(dirs []string) bool { return slices.Contains(dirs, deep) })
+
+	page := filepath.Join(deep, "page.md")
+	if err := os.WriteFile(page, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange(t, w, page)
+}
+
+func TestNotifyWatcher_RemovedDirectoryIsUnregistered(t *testing.T) {
+	root := t.TempDir()
+	chapter := filepath.Join(root, "content", "old")
+	if err := os.MkdirAll(filepath.Join(chapter, "nested"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestNotifyWatcher(t, root)
+
+	if err := os.RemoveAll(chapter); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange(t, w, chapter)
+	waitForDirs(t, w, func(dirs []string) bool {
+		return !slices.Contains(dirs, chapter) && !slices.Contains(dirs, filepath.Join(chapter, "nested"))
+	})
+}
+
+func TestNotifyWatcher_RenameIsAChange(t *testing.T) {
+	root := t.TempDir()
+	oldDir := filepath.Join(root, "draft")
+	if err := os.Mkdir(oldDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	oldPage := filepath.Join(root, "page.md")
+	if err := os.WriteFile(oldPage, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestNotifyWatcher(t, root)
+
+	if err := os.Rename(oldPage, filepath.Join(root, "renamed.md")); err != nil {
+		t.Fatal(err)
+	}
+	waitForChange(t, w, oldPage)
+
+	newDir := filepath.Join(root, "published")
+	if err := os.Rename(oldDir, newDir); err != nil {
+		t.Fatal(err)
+	}
+	waitForDirs(t, w, func(dirs []string) bool {
+		return !slices.Contains(dirs, oldDir) && slices.Contains(dirs, newDir)
+	})
+}
+
+func TestNotifyWatcher_IgnoredPathsProduceNoChanges(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "public"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w := newTestNotifyWatcher(t, root)
+
+	if err := os.WriteFile(filepath.Join(root, "public", "index.html"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "notes.swp"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(root, "marker.md")
+	if err := os.WriteFile(marker, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(watchTimeout)
+	for {
+		select {
+		case got := <-w.Changes():
+			if got == marker {
+				return
+			}
+			t.Fatalf("unexpected change for ignored path %s", got)
+		case <-deadline:
+			t.Fatal("timed out waiting for marker change")
+		}
+	}
+}
+
+func TestNotifyWatcher_RemovedIgnoredDirectoryProducesNoChange(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"public", "node_modules"} {
+		if err := os.MkdirAll(filepath.Join(root, dir, "sub"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := newTestNotifyWatcher(t, root)
+
+	for _, dir := range []string{"public", "node_modules"} {
+		if err := os.RemoveAll(filepath.Join(root, dir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	marker := filepath.Join(root, "marker.md")
+	if err := os.WriteFile(marker, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(watchTimeout)
+	for {
+		select {
+		case got := <-w.Changes():
+			if got == marker {
+				return
+			}
+			t.Fatalf("unexpected change for removed ignored path %s", got)
+		case <-deadline:
+			t.Fatal("timed out waiting for marker change")
+		}
+	}
+}
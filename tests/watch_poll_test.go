@@ -0,0 +1,101 @@
+package dockerinternal_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fortihugorunner/dockerinternal"
+)
+
+func TestResolveWatchBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		backend  dockerinternal.WatchBackend
+		dir      string
+		isWSL    bool
+		expected dockerinternal.WatchBackend
+	}{
+		{"auto wsl mnt", dockerinternal.WatchBackendAuto, "/mnt/c/Users/test/workshop", true, dockerinternal.WatchBackendPoll},
+		{"auto wsl home", dockerinternal.WatchBackendAuto, "/home/test/workshop", true, dockerinternal.WatchBackendNotify},
+		{"auto linux mnt", dockerinternal.WatchBackendAuto, "/mnt/c/Users/test/workshop", false, dockerinternal.WatchBackendNotify},
+		{"explicit poll", dockerinternal.WatchBackendPoll, "/home/test/workshop", false, dockerinternal.WatchBackendPoll},
+		{"explicit notify on wsl mnt", dockerinternal.WatchBackendNotify, "/mnt/c/workshop", true, dockerinternal.WatchBackendNotify},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := dockerinternal.ResolveWatchBackend(tt.backend, tt.dir, tt.isWSL); result != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseWatchBackend(t *testing.T) {
+	for _, valid := range []string{"auto", "notify", "poll", "POLL"} {
+		if _, err := dockerinternal.ParseWatchBackend(valid); err != nil {
+			t.Errorf("ParseWatchBackend(%q): unexpected error: %v", valid, err)
+		}
+	}
+	if _, err := dockerinternal.ParseWatchBackend("inotify"); err == nil {
+		t.Error("ParseWatchBackend(\"inotify\"): expected error")
+	}
+}
+
+func TestPollWatcher(t *testing.T) {
+	root := t.TempDir()
+	page := filepath.Join(root, "page.md")
+	if err := os.WriteFile(page, []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "public"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := dockerinternal.NewPollWatcher(root, dockerinternal.NewIgnoreMatcher(dockerinternal.DefaultWatchIgnores), 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewPollWatcher: %v", err)
+	}
+	defer w.Close()
+
+	expectChange := func(path string) {
+		t.Helper()
+		deadline := time.After(watchTimeout)
+		for {
+			select {
+			case got := <-w.Changes():
+				if got == path {
+					return
+				}
+				if filepath.Dir(got) == filepath.Join(root, "public") {
+					t.Fatalf("unexpected change for ignored path %s", got)
+				}
+			case err := <-w.Errors():
+				t.Fatalf("watcher error: %v", err)
+			case <-deadline:
+				t.Fatalf("timed out waiting for change to %s", path)
+			}
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "public", "index.html"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	added := filepath.Join(root, "new.md")
+	if err := os.WriteFile(added, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(added)
+
+	if err := os.WriteFile(page, []byte("version two"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(page)
+
+	if err := os.Remove(added); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(added)
+}